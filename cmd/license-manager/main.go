@@ -1,12 +1,20 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/AmrEsam0/license-manager/pkg/clock"
+	"github.com/AmrEsam0/license-manager/pkg/crypto"
 	"github.com/AmrEsam0/license-manager/pkg/license"
 	"github.com/joho/godotenv"
 )
@@ -28,6 +36,16 @@ func main() {
 		return
 	}
 
+	// Commands that do not need a license manager
+	switch os.Args[1] {
+	case "keygen":
+		handleKeygen()
+		return
+	case "timestamp":
+		handleTimestamp()
+		return
+	}
+
 	manager, err := license.NewManager()
 	if err != nil {
 		fmt.Printf("Error initializing license manager: %v\n", err)
@@ -43,8 +61,18 @@ func main() {
 		handleCheck(manager)
 	case "view":
 		handleView(manager)
+	case "list":
+		handleList(manager)
 	case "revoke":
 		handleRevoke(manager)
+	case "rekey":
+		handleRekey(manager)
+	case "activation-request":
+		handleActivationRequest(manager)
+	case "issue":
+		handleIssue(manager)
+	case "activate":
+		handleActivate(manager)
 	default:
 		fmt.Printf("Unknown command: %s\n", os.Args[1])
 		printUsage()
@@ -56,31 +84,70 @@ func printUsage() {
 	fmt.Println("License Manager")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  license-manager create <product_name> <max_days|lifetime>")
-	fmt.Println("  license-manager check <product_name>")
+	fmt.Println("  license-manager create <product_name> <max_days|lifetime|calendar> [options]")
+	fmt.Println("  license-manager check <product_name> [--dry-run]")
 	fmt.Println("  license-manager view <product_name>")
-	fmt.Println("  license-manager pcid")
+	fmt.Println("  license-manager list [--json]")
+	fmt.Println("  license-manager pcid [--explain [--redact]]")
 	fmt.Println("  license-manager revoke <product_name>")
+	fmt.Println("  license-manager rekey")
+	fmt.Println("  license-manager keygen")
+	fmt.Println("  license-manager timestamp <out_file>")
+	fmt.Println("  license-manager activation-request <product_name> [--out <file>]")
+	fmt.Println("  license-manager issue <request_file> <max_days|lifetime|calendar> [options]")
+	fmt.Println("  license-manager activate <license_file>")
 	fmt.Println()
 	fmt.Println("Commands:")
-	fmt.Println("  pcid             Show the current PC ID")
+	fmt.Println("  pcid             Show the current PC ID (--explain lists its hardware components)")
 	fmt.Println("  create           Create a new license")
 	fmt.Println("  check            Validate and check license status for specific product")
 	fmt.Println("  view             View license details without updating usage for specific product")
+	fmt.Println("  list             List all licenses in the license directory")
 	fmt.Println("  revoke           Revoke the license for specific product")
+	fmt.Println("  rekey            Re-encrypt all licenses under the active master key")
+	fmt.Println("  keygen           Generate an Ed25519 key pair for signed licenses")
+	fmt.Println("  timestamp        Write a timestamp file signed with LICENSE_SIGNING_KEY")
+	fmt.Println("  activation-request  Create an offline activation request for this PC")
+	fmt.Println("  issue            Issue a license file answering an activation request")
+	fmt.Println("  activate         Install a license file issued for this PC (by issue or create --pcid)")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  license-manager create \"My Product\" 30")
 	fmt.Println("  license-manager create \"My Product\" lifetime")
+	fmt.Println("  license-manager create \"My Product\" 365 --feature export --limit max_users=10")
+	fmt.Println("  license-manager create \"My Product\" calendar --not-after 2026-12-31")
 	fmt.Println("  license-manager check \"My Product\"")
 	fmt.Println("  license-manager view \"My Product\"")
+	fmt.Println("  license-manager list --json")
 	fmt.Println("  license-manager revoke \"My Product\"")
 	fmt.Println()
 	fmt.Println("Environment Variables:")
 	fmt.Println("  LICENSE_MASTER_KEY              Master encryption key (recommended)")
+	fmt.Println("  LICENSE_KEY_PROVIDER            Master key source: env[:NAME], file:PATH, prompt, command:PROGRAM")
+	fmt.Println("  LICENSE_KDF                     KDF for new files: pbkdf2, scrypt or argon2id with params")
+	fmt.Println("  LICENSE_RETIRED_KEYS            Comma-separated retired master keys (for rekey)")
+	fmt.Println("  LICENSE_SIGNING_KEY             Ed25519 private key for issuing signed licenses")
+	fmt.Println("  LICENSE_PUBLIC_KEY              Ed25519 public key for verifying signed licenses")
 	fmt.Println("  LICENSE_DEFAULT_DAYS            Default license duration in days")
 	fmt.Println("  LICENSE_LIFETIME_DAYS           Days representing lifetime license")
 	fmt.Println("  LICENSE_DIR                     Directory to store license files (optional)")
+	fmt.Println("  LICENSE_STATE_DIR               Directory of usage records, outside LICENSE_DIR (optional)")
+	fmt.Println("  LICENSE_ALLOW_LEGACY_SERIALS    Accept pre-HMAC serials during migration (default true)")
+	fmt.Println("  LICENSE_GRACE_DAYS              Default grace days issued with new licenses (default 0)")
+	fmt.Println("  LICENSE_WARN_DAYS               Remaining days at which check warns of expiry (default 7)")
+	fmt.Println("  LICENSE_HARDWARE_MATCH          Hardware components that must match when the PC ID changed (default 0 = exact)")
+	fmt.Println("  LICENSE_CONTAINER_POLICY        allow, warn, alternate or refuse inside containers (default allow)")
+	fmt.Println("  LICENSE_VM_POLICY               allow, warn, alternate or refuse inside virtual machines (default allow)")
+	fmt.Println("  LICENSE_HOST_ID_FILE            Machine ID file bound to under the alternate policy")
+	fmt.Println("  LICENSE_TIME_SERVER             URL whose HTTP Date header is preferred over the OS clock")
+	fmt.Println("  LICENSE_TIMESTAMP_FILE          Signed timestamp file the clock cannot be set back before")
+	fmt.Println("  LICENSE_TIMESTAMP_KEY           Ed25519 public key verifying LICENSE_TIMESTAMP_FILE")
+	fmt.Println("  LICENSE_CLOCK_SKEW              Clock skew tolerated before reporting a rollback (default 5m)")
+	fmt.Println("  LICENSE_SYSTEM_TIME_ANCHORS     Check the clock against system file times (default true)")
+	fmt.Println()
+	fmt.Println("Exit codes of check and view:")
+	fmt.Println("  0 valid, 1 other error, 2 not found, 3 corrupted, 4 wrong machine,")
+	fmt.Println("  5 serial mismatch, 6 expired, 7 clock rollback, 8 revoked, 9 replayed")
 	fmt.Println()
 	fmt.Println("Notes:")
 	fmt.Println("  - License files are created in the directory specified by LICENSE_DIR or current directory")
@@ -89,44 +156,112 @@ func printUsage() {
 }
 
 func handlePCID(manager *license.Manager) {
+	flags := flag.NewFlagSet("pcid", flag.ExitOnError)
+	explain := flags.Bool("explain", false, "list the hardware components behind the PC ID")
+	redact := flags.Bool("redact", false, "mask raw component values (with --explain)")
+	flags.Parse(os.Args[2:])
+
 	pcId := manager.GetPCID()
 	fmt.Printf("PC ID: %s\n", pcId)
+	if !*explain {
+		return
+	}
+
+	probes, err := manager.ExplainPCID()
+	if err != nil {
+		fmt.Printf("Error explaining PC ID: %v\n", err)
+		os.Exit(1)
+	}
+
+	env := manager.Environment()
+	fmt.Printf("Environment: %s (policy: %s)\n", env, manager.EnvironmentPolicy())
+	for _, evidence := range env.Evidence {
+		fmt.Printf("  %s\n", evidence)
+	}
+
+	fmt.Println()
+	fmt.Println("Components:")
+	for _, probe := range probes {
+		if *redact {
+			probe = probe.Redacted()
+		}
+		if !probe.Found {
+			fmt.Printf("  %-8s missing  %s\n", probe.Name, probe.Source)
+			continue
+		}
+		fmt.Printf("  %-8s found    %s\n", probe.Name, probe.Source)
+		fmt.Printf("           value: %s\n", probe.Value)
+		fmt.Printf("           hash:  %s\n", probe.Fingerprint)
+	}
 }
 
+// licenseOptionsHelp describes the options shared by create and issue
+const licenseOptionsHelp = `  --not-before <date>     First valid date (YYYY-MM-DD or RFC3339)
+  --not-after <date>      Last valid date; with max_days the license is hybrid
+  --grace <days>          Days the license keeps working after it expires (0 for none,
+                          default LICENSE_GRACE_DAYS)
+  --feature <name>        Grant a feature flag (repeatable)
+  --limit <name>=<n>      Set a numeric limit (repeatable)
+  --meta <key>=<value>    Attach metadata (repeatable)`
+
 func handleCreate(manager *license.Manager) {
 	if len(os.Args) < 4 {
-		fmt.Println("Usage: license-manager create <product_name> <max_days|lifetime>")
+		fmt.Println("Usage: license-manager create <product_name> <max_days|lifetime|calendar> [options]")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  --pcid <id>             Issue for another machine (output of 'license-manager pcid')")
+		fmt.Println("  --out <file>            Write the license file here instead of the license directory")
+		fmt.Println(licenseOptionsHelp)
 		fmt.Println()
 		fmt.Println("Examples:")
 		fmt.Println("  license-manager create \"My Product\" 30")
+		fmt.Println("  license-manager create \"My Product\" 365 --pcid 3aca2461e6642dafb18aff32f57af6c6 --out customer.license")
 		fmt.Println("  license-manager create \"My Product\" lifetime")
+		fmt.Println("  license-manager create \"My Product\" 365 --feature export --limit max_users=10")
+		fmt.Println("  license-manager create \"My Product\" calendar --not-after 2026-12-31")
+		fmt.Println("  license-manager create \"My Product\" 30 --not-after 2026-12-31")
 		return
 	}
 
 	productName := os.Args[2]
-	daysStr := os.Args[3]
 
-	// Parse max days
-	var maxDays int
-	var isLifetime bool
-	var err error
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	pcid := flags.String("pcid", "", "PC ID of the machine to license")
+	out := flags.String("out", "", "license file to write instead of the license directory")
+	req, err := parseLicenseOptions(flags, productName, os.Args[3], os.Args[4:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	req.TargetPCID = *pcid
 
-	if strings.ToLower(strings.TrimSpace(daysStr)) == "lifetime" {
-		maxDays = 99999
-		isLifetime = true
-	} else {
-		maxDays, err = strconv.Atoi(daysStr)
-		if err != nil || maxDays <= 0 {
-			fmt.Println("Invalid max days. Please provide a positive integer or 'lifetime'.")
+	// Licenses for other machines are written to a separate file so they never
+	// replace the license of the machine running this command
+	if *pcid != "" && *out == "" {
+		*out = fmt.Sprintf("%s_%.8s.license", sanitizeProductName(productName), strings.ToLower(strings.TrimSpace(*pcid)))
+	}
+
+	if *out != "" {
+		if _, err := os.Stat(*out); err == nil {
+			fmt.Printf("Error: %s already exists\n", *out)
+			return
+		}
+		data, issued, err := manager.Issue(req)
+		if err != nil {
+			fmt.Printf("Error creating license: %v\n", err)
+			return
+		}
+		if err := os.WriteFile(*out, data, 0644); err != nil {
+			fmt.Printf("Error writing license file: %v\n", err)
 			return
 		}
-		isLifetime = maxDays >= 99999
-	}
 
-	req := license.CreateLicenseRequest{
-		ProductName: productName,
-		MaxDays:     maxDays,
-		IsLifetime:  isLifetime,
+		fmt.Printf("License created successfully!\n")
+		fmt.Printf("File: %s\n", *out)
+		fmt.Printf("Computer ID: %s\n", issued.PCId)
+		printIssuedLicense(issued)
+		fmt.Println("Install it on the target machine with: license-manager activate <file>")
+		return
 	}
 
 	createdLicense, err := manager.Create(req)
@@ -141,38 +276,244 @@ func handleCreate(manager *license.Manager) {
 
 	fmt.Printf("License created successfully!\n")
 	fmt.Printf("File: %s\n", filename)
-	fmt.Printf("Computer ID: %s\n", manager.GetPCID())
-	fmt.Printf("Serial: %s\n", createdLicense.Serial)
-	if isLifetime {
+	fmt.Printf("Computer ID: %s\n", createdLicense.PCId)
+	printIssuedLicense(createdLicense)
+}
+
+func handleActivationRequest(manager *license.Manager) {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: license-manager activation-request <product_name> [--out <file>]")
+		fmt.Println()
+		fmt.Println("Creates a request file to send to the vendor. Install the license")
+		fmt.Println("they send back with: license-manager activate <file>")
+		return
+	}
+
+	productName := os.Args[2]
+	flags := flag.NewFlagSet("activation-request", flag.ExitOnError)
+	out := flags.String("out", sanitizeProductName(productName)+".request", "request file to write")
+	flags.Parse(os.Args[3:])
+
+	req, err := manager.CreateActivationRequest(productName)
+	if err != nil {
+		fmt.Printf("Error creating activation request: %v\n", err)
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		fmt.Printf("Error encoding activation request: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Printf("Error writing activation request: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Activation request created: %s\n", *out)
+	fmt.Printf("Product: %s\n", req.ProductName)
+	fmt.Printf("Computer ID: %s\n", req.PCId)
+	fmt.Println("Send this file to your vendor, then run: license-manager activate <license_file>")
+}
+
+func handleIssue(manager *license.Manager) {
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: license-manager issue <request_file> <max_days|lifetime|calendar> [options]")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  --out <file>            License file to write (default <product>_<pcid>.license)")
+		fmt.Println(licenseOptionsHelp)
+		fmt.Println()
+		fmt.Println("Examples:")
+		fmt.Println("  license-manager issue My_Product.request 365 --feature export")
+		return
+	}
+
+	requestData, err := os.ReadFile(os.Args[2])
+	if err != nil {
+		fmt.Printf("Error reading activation request: %v\n", err)
+		os.Exit(1)
+	}
+	activationReq, err := license.ParseActivationRequest(requestData)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("issue", flag.ExitOnError)
+	out := flags.String("out", "", "license file to write")
+	req, err := parseLicenseOptions(flags, activationReq.ProductName, os.Args[3], os.Args[4:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("%s_%.8s.license", sanitizeProductName(activationReq.ProductName), activationReq.PCId)
+	}
+	if _, err := os.Stat(*out); err == nil {
+		fmt.Printf("Error: %s already exists\n", *out)
+		os.Exit(1)
+	}
+
+	data, issued, err := manager.IssueActivation(activationReq, req)
+	if err != nil {
+		fmt.Printf("Error issuing license: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Printf("Error writing license file: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("License issued successfully!\n")
+	fmt.Printf("File: %s\n", *out)
+	fmt.Printf("Computer ID: %s\n", issued.PCId)
+	printIssuedLicense(issued)
+}
+
+func handleActivate(manager *license.Manager) {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: license-manager activate <license_file>")
+		return
+	}
+
+	data, err := os.ReadFile(os.Args[2])
+	if err != nil {
+		fmt.Printf("Error reading license file: %v\n", err)
+		os.Exit(1)
+	}
+
+	lic, err := manager.Activate(data)
+	if err != nil {
+		fmt.Printf("Error activating license: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("License activated successfully!\n")
+	fmt.Printf("File: %s.license\n", sanitizeProductName(lic.ProductName))
+	printIssuedLicense(lic)
+}
+
+// parseLicenseOptions builds a create request from the days argument and license options
+func parseLicenseOptions(flags *flag.FlagSet, productName, daysStr string, args []string) (license.CreateLicenseRequest, error) {
+	var features stringList
+	limits := keyValueFlag{}
+	metadata := keyValueFlag{}
+	flags.Var(&features, "feature", "grant a feature flag (repeatable)")
+	flags.Var(limits, "limit", "set a numeric limit as name=n (repeatable)")
+	flags.Var(metadata, "meta", "attach metadata as key=value (repeatable)")
+	notBeforeStr := flags.String("not-before", "", "first valid date")
+	notAfterStr := flags.String("not-after", "", "last valid date")
+	graceDays := flags.Int("grace", 0, "grace days after expiry")
+	flags.Parse(args)
+
+	notBefore, err := parseDate(*notBeforeStr, false)
+	if err != nil {
+		return license.CreateLicenseRequest{}, fmt.Errorf("invalid --not-before: %v", err)
+	}
+	notAfter, err := parseDate(*notAfterStr, true)
+	if err != nil {
+		return license.CreateLicenseRequest{}, fmt.Errorf("invalid --not-after: %v", err)
+	}
+
+	limitValues, err := limits.ints()
+	if err != nil {
+		return license.CreateLicenseRequest{}, fmt.Errorf("invalid limit: %v", err)
+	}
+
+	// Parse max days
+	var maxDays int
+	var isLifetime bool
+	expiryMode := license.ExpiryUsageDays
+
+	switch strings.ToLower(strings.TrimSpace(daysStr)) {
+	case "lifetime":
+		maxDays = 99999
+		isLifetime = true
+	case "calendar":
+		expiryMode = license.ExpiryCalendar
+	default:
+		maxDays, err = strconv.Atoi(daysStr)
+		if err != nil || maxDays <= 0 {
+			return license.CreateLicenseRequest{}, fmt.Errorf("invalid max days %q: provide a positive integer, 'lifetime' or 'calendar'", daysStr)
+		}
+		isLifetime = maxDays >= 99999
+		if !notAfter.IsZero() {
+			expiryMode = license.ExpiryHybrid
+		}
+	}
+
+	req := license.CreateLicenseRequest{
+		ProductName: productName,
+		MaxDays:     maxDays,
+		IsLifetime:  isLifetime,
+		Features:    features,
+		Limits:      limitValues,
+		Metadata:    metadata,
+		ExpiryMode:  expiryMode,
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		GraceDays:   *graceDays,
+	}
+	// An explicit --grace 0 overrides LICENSE_GRACE_DAYS
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "grace" && *graceDays == 0 {
+			req.NoGrace = true
+		}
+	})
+	return req, nil
+}
+
+// printIssuedLicense prints the issuer-controlled fields of a newly issued license
+func printIssuedLicense(lic *license.License) {
+	fmt.Printf("Serial: %s\n", lic.Serial)
+	switch {
+	case lic.IsLifetime:
 		fmt.Printf("Type: LIFETIME license\n")
-	} else {
-		fmt.Printf("Type: %d-day license\n", maxDays)
+	case lic.Mode() == license.ExpiryCalendar:
+		fmt.Printf("Type: calendar license\n")
+	case lic.Mode() == license.ExpiryHybrid:
+		fmt.Printf("Type: %d-day hybrid license\n", lic.MaxDays)
+	default:
+		fmt.Printf("Type: %d-day license\n", lic.MaxDays)
+	}
+	printValidityWindow(lic)
+	if lic.GraceDays > 0 {
+		fmt.Printf("Grace period: %d days\n", lic.GraceDays)
 	}
-	fmt.Printf("Product: %s\n", createdLicense.ProductName)
-	fmt.Printf("Created: %s\n", createdLicense.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Product: %s\n", lic.ProductName)
+	fmt.Printf("Created: %s\n", lic.CreatedAt.Format("2006-01-02 15:04:05"))
+	printEntitlements(lic)
 }
 
 func handleCheck(manager *license.Manager) {
 	if len(os.Args) < 3 {
-		fmt.Println("Usage: license-manager check <product_name>")
+		fmt.Println("Usage: license-manager check <product_name> [--dry-run]")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  --dry-run    Run every check without recording a run or writing the license")
 		fmt.Println()
 		fmt.Println("Examples:")
 		fmt.Println("  license-manager check \"My Product\"")
-		fmt.Println("  license-manager check \"Another App\"")
+		fmt.Println("  license-manager check \"Another App\" --dry-run")
 		return
 	}
 
 	productName := os.Args[2]
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "run every check without recording a run")
+	flags.Parse(os.Args[3:])
 
-	result, err := manager.Validate(productName)
+	result, err := manager.ValidateWithOptions(productName, license.ValidateOptions{ReadOnly: *dryRun})
 	if err != nil {
 		fmt.Printf("Error checking license: %v\n", err)
-		return
+		os.Exit(1)
 	}
 
 	if !result.IsValid {
 		fmt.Printf("License validation failed: %s\n", result.ErrorMessage)
-		return
+		fmt.Printf("Status: %s\n", result.Status)
+		fmt.Printf("Reason: %s\n", result.Reason)
+		os.Exit(exitCode(result.Reason))
 	}
 
 	lic := result.License
@@ -183,16 +524,33 @@ func handleCheck(manager *license.Manager) {
 		fmt.Printf("Used days: %d (unlimited)\n", len(lic.UsageHistory))
 		fmt.Printf("Remaining days: UNLIMITED\n")
 	} else {
-		remainingDays := lic.MaxDays - len(lic.UsageHistory)
-		if remainingDays < 0 {
-			remainingDays = 0
-		}
 		fmt.Printf("License is VALID\n")
 		fmt.Printf("Product: %s\n", lic.ProductName)
-		fmt.Printf("Used days: %d/%d\n", len(lic.UsageHistory), lic.MaxDays)
-		fmt.Printf("Remaining days: %d\n", remainingDays)
+		fmt.Printf("Expiry mode: %s\n", lic.Mode())
+		if lic.Mode() == license.ExpiryCalendar {
+			fmt.Printf("Used days: %d\n", len(lic.UsageHistory))
+		} else {
+			fmt.Printf("Used days: %d/%d\n", len(lic.UsageHistory), lic.MaxDays)
+		}
+		printValidityWindow(lic)
+		fmt.Printf("Remaining days: %d\n", result.RemainingDays)
 	}
 
+	switch result.Status {
+	case license.StatusExpiringSoon:
+		fmt.Printf("WARNING: license expires soon - %d days remaining\n", result.RemainingDays)
+	case license.StatusGracePeriod:
+		fmt.Printf("WARNING: license has expired and is in its grace period - %d grace days remaining\n", result.GraceDaysRemaining)
+	}
+
+	if len(result.DriftedComponents) > 0 {
+		fmt.Printf("WARNING: hardware changed since the license was issued: %s\n", strings.Join(result.DriftedComponents, ", "))
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("WARNING: %s\n", warning)
+	}
+
+	fmt.Printf("Status: %s\n", result.Status)
 	fmt.Printf("Total runs: %d\n", lic.RunCount)
 	if lic.FirstRunDate != "" {
 		fmt.Printf("First activated: %s\n", lic.FirstRunDate)
@@ -201,6 +559,31 @@ func handleCheck(manager *license.Manager) {
 		fmt.Printf("Last used: %s\n", lic.LastUsedDate)
 	}
 	fmt.Printf("Usage history: %v\n", lic.UsageHistory)
+	printEntitlements(lic)
+	if *dryRun {
+		fmt.Println("Dry run: this run was not recorded")
+	}
+}
+
+// exitCodes are the exit statuses of check and view for each validation failure reason.
+// Other failures exit with 1.
+var exitCodes = map[license.Reason]int{
+	license.ReasonNotFound:       2,
+	license.ReasonCorrupted:      3,
+	license.ReasonWrongMachine:   4,
+	license.ReasonSerialMismatch: 5,
+	license.ReasonExpired:        6,
+	license.ReasonClockRollback:  7,
+	license.ReasonRevoked:        8,
+	license.ReasonReplayed:       9,
+}
+
+// exitCode returns the exit status for a validation failure reason
+func exitCode(reason license.Reason) int {
+	if code, ok := exitCodes[reason]; ok {
+		return code
+	}
+	return 1
 }
 
 func handleView(manager *license.Manager) {
@@ -218,7 +601,7 @@ func handleView(manager *license.Manager) {
 	licInfo, err := manager.View(productName)
 	if err != nil {
 		fmt.Printf("Error viewing license: %v\n", err)
-		return
+		os.Exit(exitCode(license.ReasonOf(err)))
 	}
 
 	fmt.Printf("License Details:\n")
@@ -226,19 +609,22 @@ func handleView(manager *license.Manager) {
 	fmt.Printf("Serial: %s\n", licInfo.Serial)
 	fmt.Printf("PC ID: %s\n", licInfo.PCId)
 	fmt.Printf("Created: %s\n", licInfo.CreatedAt.Format("2006-01-02 15:04:05"))
+	if header, err := manager.Format(productName); err == nil {
+		fmt.Printf("Format: %s\n", header)
+	}
 
 	if licInfo.IsLifetime {
 		fmt.Printf("License Type: LIFETIME\n")
 		fmt.Printf("Used days: %d (unlimited)\n", len(licInfo.UsageHistory))
 	} else {
-		remainingDays := licInfo.MaxDays - len(licInfo.UsageHistory)
-		if remainingDays < 0 {
-			remainingDays = 0
-		}
 		fmt.Printf("License Type: Time-limited\n")
-		fmt.Printf("Max days: %d\n", licInfo.MaxDays)
+		fmt.Printf("Expiry mode: %s\n", licInfo.Mode())
+		if licInfo.Mode() != license.ExpiryCalendar {
+			fmt.Printf("Max days: %d\n", licInfo.MaxDays)
+		}
+		printValidityWindow(licInfo)
 		fmt.Printf("Used days: %d\n", len(licInfo.UsageHistory))
-		fmt.Printf("Remaining days: %d\n", remainingDays)
+		fmt.Printf("Remaining days: %d\n", manager.RemainingDays(licInfo))
 	}
 
 	fmt.Printf("Total runs: %d\n", licInfo.RunCount)
@@ -250,6 +636,90 @@ func handleView(manager *license.Manager) {
 		fmt.Printf("Last used: %s\n", licInfo.LastUsedDate)
 	}
 	fmt.Printf("Usage history: %v\n", licInfo.UsageHistory)
+	printEntitlements(licInfo)
+	if len(licInfo.Fingerprints) > 0 {
+		fmt.Println("Hardware fingerprints (compare with 'pcid --explain'):")
+		for _, name := range slices.Sorted(maps.Keys(licInfo.Fingerprints)) {
+			fmt.Printf("  %-8s %s\n", name, licInfo.Fingerprints[name])
+		}
+	}
+}
+
+func handleList(manager *license.Manager) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the licenses as JSON")
+	flags.Parse(os.Args[2:])
+
+	summaries, err := manager.List()
+	if err != nil {
+		fmt.Printf("Error listing licenses: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(summaries, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding licenses: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	if len(summaries) == 0 {
+		fmt.Println("No license files found.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PRODUCT\tTYPE\tREMAINING\tACTIVATED\tTHIS PC\tSTATUS")
+	for _, summary := range summaries {
+		if summary.Error != "" && summary.ExpiryMode == "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\n", summary.ProductName, summary.Status)
+			continue
+		}
+		remaining := strconv.Itoa(summary.RemainingDays) + " days"
+		if summary.IsLifetime {
+			remaining = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", summary.ProductName, licenseType(summary),
+			remaining, yesNo(summary.IsActivated), yesNo(summary.ValidForThisPC), summary.Status)
+	}
+	w.Flush()
+
+	printedHeader := false
+	for _, summary := range summaries {
+		if summary.Error == "" {
+			continue
+		}
+		if !printedHeader {
+			fmt.Println()
+			fmt.Println("Problems:")
+			printedHeader = true
+		}
+		fmt.Printf("  %s: %s\n", summary.ProductName, summary.Error)
+	}
+}
+
+// licenseType describes the expiry of a listed license
+func licenseType(summary license.LicenseSummary) string {
+	switch {
+	case summary.IsLifetime:
+		return "lifetime"
+	case summary.ExpiryMode == license.ExpiryCalendar:
+		return "calendar"
+	case summary.ExpiryMode == license.ExpiryHybrid:
+		return fmt.Sprintf("%d-day hybrid", summary.MaxDays)
+	default:
+		return fmt.Sprintf("%d-day", summary.MaxDays)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
 }
 
 func handleRevoke(manager *license.Manager) {
@@ -273,6 +743,161 @@ func handleRevoke(manager *license.Manager) {
 	fmt.Printf("License for \"%s\" has been revoked successfully.\n", productName)
 }
 
+func handleRekey(manager *license.Manager) {
+	results, err := manager.Rekey()
+	if err != nil {
+		fmt.Printf("Error rekeying licenses: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No license files found.")
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAILED  %s: %v\n", result.File, result.Err)
+			continue
+		}
+		fmt.Printf("OK      %s (key %s -> %s)\n", result.File, result.OldKeyID, result.NewKeyID)
+	}
+
+	fmt.Printf("Rekeyed %d of %d license files.\n", len(results)-failed, len(results))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func handleKeygen() {
+	publicKey, privateKey, err := crypto.GenerateSigningKeyPair()
+	if err != nil {
+		fmt.Printf("Error generating key pair: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Public key:  %s\n", publicKey)
+	fmt.Printf("Private key: %s\n", privateKey)
+	fmt.Println()
+	fmt.Println("Keep the private key on your issuing machine only (LICENSE_SIGNING_KEY).")
+	fmt.Println("Embed the public key in shipped binaries (LICENSE_PUBLIC_KEY or NewManagerWithPublicKey).")
+}
+
+func handleTimestamp() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: license-manager timestamp <out_file>")
+		os.Exit(1)
+	}
+	privateKey, err := crypto.ParsePrivateKey(os.Getenv("LICENSE_SIGNING_KEY"))
+	if err != nil {
+		fmt.Printf("Error reading LICENSE_SIGNING_KEY: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	data, err := clock.SignTimestamp(now, privateKey)
+	if err != nil {
+		fmt.Printf("Error signing timestamp: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(os.Args[2], data, 0644); err != nil {
+		fmt.Printf("Error writing timestamp file: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Signed timestamp %s written to %s\n", now.UTC().Format(time.RFC3339), os.Args[2])
+	fmt.Println("Clients verify it with the public key in LICENSE_TIMESTAMP_KEY.")
+}
+
+// printValidityWindow prints the calendar window when the license has one
+func printValidityWindow(lic *license.License) {
+	if lic.NotBefore != nil {
+		fmt.Printf("Valid from: %s\n", lic.NotBefore.Local().Format("2006-01-02 15:04:05"))
+	}
+	if lic.NotAfter != nil {
+		fmt.Printf("Valid until: %s\n", lic.NotAfter.Local().Format("2006-01-02 15:04:05"))
+	}
+}
+
+// parseDate parses YYYY-MM-DD in local time or RFC3339. Plain dates used as an end
+// bound cover the whole day. An empty string yields the zero time.
+func parseDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC3339, got %q", value)
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// printEntitlements prints feature flags, limits and metadata when present
+func printEntitlements(lic *license.License) {
+	if len(lic.Features) > 0 {
+		fmt.Printf("Features: %s\n", strings.Join(lic.Features, ", "))
+	}
+	for _, name := range slices.Sorted(maps.Keys(lic.Limits)) {
+		fmt.Printf("Limit %s: %d\n", name, lic.Limits[name])
+	}
+	for _, key := range slices.Sorted(maps.Keys(lic.Metadata)) {
+		fmt.Printf("Metadata %s: %s\n", key, lic.Metadata[key])
+	}
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// keyValueFlag is a repeatable key=value flag
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for key, value := range f {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	f[strings.TrimSpace(key)] = val
+	return nil
+}
+
+// ints converts the values to integers
+func (f keyValueFlag) ints() (map[string]int, error) {
+	result := make(map[string]int, len(f))
+	for key, value := range f {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer", key)
+		}
+		result[key] = n
+	}
+	return result, nil
+}
+
 // sanitizeProductName removes invalid characters from product name for filename use
 func sanitizeProductName(name string) string {
 	// Replace spaces and invalid characters with underscores
@@ -5,3 +5,7 @@ go 1.24.3
 require golang.org/x/crypto v0.39.0
 
 require github.com/joho/godotenv v1.5.1
+
+require golang.org/x/term v0.32.0
+
+require golang.org/x/sys v0.33.0 // indirect
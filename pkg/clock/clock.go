@@ -0,0 +1,226 @@
+package clock
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock tells the license manager what time it is. Expiry, grace periods, usage history
+// and rollback detection are all evaluated against it.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the operating system clock. It is the default clock.
+type System struct{}
+
+// Now returns the current OS time
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// Fake is a manually driven clock for tests. It is safe for concurrent use.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake creates a fake clock that reads t until it is moved
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set moves the fake clock to t, which may be in the past
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+}
+
+// Advance moves the fake clock by d, backwards when d is negative
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// TimeSource is a source of time that may be unavailable, such as a time server
+type TimeSource interface {
+	Now() (time.Time, error)
+}
+
+// Trusted asks its sources in order and uses the first answer, falling back to the OS
+// clock when none answers. It lets deployments prefer a time the user cannot simply set.
+type Trusted struct {
+	Sources []TimeSource
+	// Fallback is used when no source answers, the system clock when nil
+	Fallback Clock
+	// MaxAge reuses the last answer for this long, advanced by the time elapsed since on the
+	// monotonic clock, instead of asking the sources on every call. Zero asks every time.
+	MaxAge time.Duration
+
+	mu         sync.Mutex
+	resolvedAt time.Time
+	answer     time.Time
+	answered   bool
+}
+
+// Now returns the time of the first source that answers, or the fallback time. Source times
+// are returned in local time like the OS clock, so usage days start at local midnight.
+func (t *Trusted) Now() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.MaxAge > 0 && !t.resolvedAt.IsZero() {
+		if elapsed := time.Since(t.resolvedAt); elapsed < t.MaxAge {
+			if !t.answered {
+				return t.fallback().Now()
+			}
+			return t.answer.Add(elapsed).Local()
+		}
+	}
+
+	now, _, err := t.Resolve()
+	t.resolvedAt = time.Now()
+	t.answer, t.answered = now, err == nil
+	if err != nil {
+		return t.fallback().Now()
+	}
+	return now.Local()
+}
+
+// Resolve returns the time of the first source that answers and its index. The error
+// lists why each source failed when none answered.
+func (t *Trusted) Resolve() (time.Time, int, error) {
+	var failures []string
+	for i, source := range t.Sources {
+		now, err := source.Now()
+		if err == nil {
+			return now, i, nil
+		}
+		failures = append(failures, err.Error())
+	}
+	if len(failures) == 0 {
+		return time.Time{}, -1, fmt.Errorf("no trusted time sources configured")
+	}
+	return time.Time{}, -1, fmt.Errorf("no trusted time source available: %s", strings.Join(failures, "; "))
+}
+
+// fallback returns the clock used when no source answers
+func (t *Trusted) fallback() Clock {
+	if t.Fallback == nil {
+		return System{}
+	}
+	return t.Fallback
+}
+
+// HTTPDateSource reads the Date header of an HTTP server, such as a time server on the
+// local network that client machines cannot reconfigure
+type HTTPDateSource struct {
+	URL string
+	// Client sends the request, a client with a 5 second timeout when nil
+	Client *http.Client
+}
+
+// Now sends a HEAD request and parses the Date response header
+func (s *HTTPDateSource) Now() (time.Time, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	resp, err := client.Head(s.URL)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time server %s: %v", s.URL, err)
+	}
+	resp.Body.Close()
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, fmt.Errorf("time server %s sent no Date header", s.URL)
+	}
+	now, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time server %s sent an invalid Date header: %v", s.URL, err)
+	}
+	return now, nil
+}
+
+// signedTimestamp is the JSON format of a signed timestamp file
+type signedTimestamp struct {
+	Time      string `json:"time"`
+	Signature string `json:"signature"`
+}
+
+// SignTimestamp creates the contents of a signed timestamp file for t.
+// Vendors publish these periodically; clients read them with SignedTimestampSource.
+func SignTimestamp(t time.Time, privateKey ed25519.PrivateKey) ([]byte, error) {
+	stamp := t.UTC().Format(time.RFC3339)
+	return json.MarshalIndent(signedTimestamp{
+		Time:      stamp,
+		Signature: hex.EncodeToString(ed25519.Sign(privateKey, []byte(stamp))),
+	}, "", "  ")
+}
+
+// SignedTimestampSource reads a timestamp file signed by the vendor. The signed time is the
+// earliest the current time can be, so the source answers with the later of the signed time
+// and the clock; a clock set back before the last published timestamp cannot go unnoticed.
+type SignedTimestampSource struct {
+	Path      string
+	PublicKey ed25519.PublicKey
+	// Clock supplies the time after the signed timestamp, the system clock when nil
+	Clock Clock
+}
+
+// Now verifies the timestamp file and returns the later of its time and the clock
+func (s *SignedTimestampSource) Now() (time.Time, error) {
+	signed, err := s.Timestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var c Clock = System{}
+	if s.Clock != nil {
+		c = s.Clock
+	}
+	if now := c.Now(); now.After(signed) {
+		return now, nil
+	}
+	return signed, nil
+}
+
+// Timestamp verifies the timestamp file and returns the signed time
+func (s *SignedTimestampSource) Timestamp() (time.Time, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read timestamp file: %v", err)
+	}
+	var stamp signedTimestamp
+	if err := json.Unmarshal(data, &stamp); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse timestamp file: %v", err)
+	}
+	signature, err := hex.DecodeString(stamp.Signature)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp signature encoding: %v", err)
+	}
+	if len(s.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(s.PublicKey, []byte(stamp.Time), signature) {
+		return time.Time{}, fmt.Errorf("timestamp file %s has an invalid signature", s.Path)
+	}
+	signed, err := time.Parse(time.RFC3339, stamp.Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time in timestamp file: %v", err)
+	}
+	return signed, nil
+}
@@ -0,0 +1,160 @@
+package clock
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// staticSource is a time source with a fixed answer
+type staticSource struct {
+	now time.Time
+	err error
+}
+
+func (s staticSource) Now() (time.Time, error) {
+	return s.now, s.err
+}
+
+// countingSource counts how often it is asked
+type countingSource struct {
+	now   time.Time
+	calls int
+}
+
+func (s *countingSource) Now() (time.Time, error) {
+	s.calls++
+	return s.now, nil
+}
+
+// TestTrustedMaxAge tests that a trusted clock reuses an answer within MaxAge
+func TestTrustedMaxAge(t *testing.T) {
+	serverTime := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	source := &countingSource{now: serverTime}
+	trusted := &Trusted{Sources: []TimeSource{source}, MaxAge: time.Hour}
+
+	first := trusted.Now()
+	second := trusted.Now()
+	if source.calls != 1 {
+		t.Errorf("Expected the source to be asked once, got %d calls", source.calls)
+	}
+	if second.Before(first) || second.Sub(serverTime) > time.Minute {
+		t.Errorf("Expected the cached answer advanced by the elapsed time, got %v then %v", first, second)
+	}
+
+	trusted.MaxAge = 0
+	trusted.Now()
+	if source.calls != 2 {
+		t.Errorf("Expected the source to be asked again without MaxAge, got %d calls", source.calls)
+	}
+}
+
+// TestFake tests that the fake clock only moves when told to
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Errorf("Expected %v, got %v", start, c.Now())
+	}
+	c.Advance(48 * time.Hour)
+	if want := start.AddDate(0, 0, 2); !c.Now().Equal(want) {
+		t.Errorf("Expected %v after advancing, got %v", want, c.Now())
+	}
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Errorf("Expected %v after setting back, got %v", start, c.Now())
+	}
+}
+
+// TestTrustedPrefersFirstAvailableSource tests the order of the trusted time chain
+func TestTrustedPrefersFirstAvailableSource(t *testing.T) {
+	osTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	serverTime := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	trusted := &Trusted{
+		Sources: []TimeSource{
+			staticSource{err: errors.New("offline")},
+			staticSource{now: serverTime},
+		},
+		Fallback: NewFake(osTime),
+	}
+	if now := trusted.Now(); !now.Equal(serverTime) {
+		t.Errorf("Expected the second source's time %v, got %v", serverTime, now)
+	}
+	if _, index, _ := trusted.Resolve(); index != 1 {
+		t.Errorf("Expected source 1 to answer, got %d", index)
+	}
+
+	trusted.Sources = trusted.Sources[:1]
+	if now := trusted.Now(); !now.Equal(osTime) {
+		t.Errorf("Expected the fallback time %v when no source answers, got %v", osTime, now)
+	}
+	if _, _, err := trusted.Resolve(); err == nil {
+		t.Error("Expected an error when no source answers")
+	}
+}
+
+// TestHTTPDateSource tests reading the time from a time server's Date header
+func TestHTTPDateSource(t *testing.T) {
+	serverTime := time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	now, err := (&HTTPDateSource{URL: server.URL}).Now()
+	if err != nil {
+		t.Fatalf("Failed to read time server: %v", err)
+	}
+	if !now.Equal(serverTime) {
+		t.Errorf("Expected %v, got %v", serverTime, now)
+	}
+
+	server.Close()
+	if _, err := (&HTTPDateSource{URL: server.URL}).Now(); err == nil {
+		t.Error("Expected an error when the time server is unreachable")
+	}
+}
+
+// TestSignedTimestampSource tests that a signed timestamp is a floor for the clock
+func TestSignedTimestampSource(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signed := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	data, err := SignTimestamp(signed, priv)
+	if err != nil {
+		t.Fatalf("Failed to sign timestamp: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "timestamp.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write timestamp file: %v", err)
+	}
+
+	clock := NewFake(signed.AddDate(0, 0, -30))
+	source := &SignedTimestampSource{Path: path, PublicKey: pub, Clock: clock}
+	now, err := source.Now()
+	if err != nil {
+		t.Fatalf("Failed to read signed timestamp: %v", err)
+	}
+	if !now.Equal(signed) {
+		t.Errorf("Expected a clock set back to be raised to %v, got %v", signed, now)
+	}
+
+	clock.Set(signed.AddDate(0, 0, 5))
+	if now, _ := source.Now(); !now.Equal(clock.Now()) {
+		t.Errorf("Expected a clock past the timestamp to be used, got %v", now)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := (&SignedTimestampSource{Path: path, PublicKey: otherPub}).Now(); err == nil {
+		t.Error("Expected a timestamp signed by another key to be rejected")
+	}
+}
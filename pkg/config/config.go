@@ -1,10 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds the configuration for the license manager
@@ -15,6 +17,65 @@ type Config struct {
 
 	// Security settings
 	MasterKey string
+
+	// AllowLegacySerials keeps accepting pre-HMAC serials during migration
+	AllowLegacySerials bool
+
+	// GraceDays is the default grace period issued with new licenses
+	GraceDays int
+	// WarnDays is the remaining-days threshold at which validation reports expiring soon
+	WarnDays int
+
+	// HardwareMatchThreshold is the number of hardware components that must still match
+	// when the PC ID has changed. Zero requires an exact PC ID match.
+	HardwareMatchThreshold int
+
+	// ContainerPolicy and VMPolicy decide what happens when running inside a container or
+	// virtual machine, where machine IDs and MAC addresses are often ephemeral or cloned
+	ContainerPolicy EnvironmentPolicy
+	VMPolicy        EnvironmentPolicy
+	// HostIDFile is the machine ID file bound to under the alternate policy,
+	// typically a host ID mounted into the container
+	HostIDFile string
+
+	// TimeServer is the URL of a time server whose HTTP Date header is preferred over the
+	// OS clock when it answers
+	TimeServer string
+	// TimestampFile is a vendor-signed timestamp that the clock cannot be set back before,
+	// verified with the hex-encoded Ed25519 TimestampKey
+	TimestampFile string
+	TimestampKey  string
+
+	// ClockSkew is how far the clock may be behind the latest recorded use before validation
+	// reports a clock rollback, to tolerate time synchronization adjustments
+	ClockSkew time.Duration
+	// SystemTimeAnchors also treats the modification times of known system files as times the
+	// clock must not be behind
+	SystemTimeAnchors bool
+}
+
+// EnvironmentPolicy decides how licenses behave inside a container or virtual machine
+type EnvironmentPolicy string
+
+const (
+	// PolicyAllow binds to the hardware as on bare metal
+	PolicyAllow EnvironmentPolicy = "allow"
+	// PolicyWarn binds to the hardware but adds a warning to validation results
+	PolicyWarn EnvironmentPolicy = "warn"
+	// PolicyAlternate binds to the contents of HostIDFile instead of the hardware
+	PolicyAlternate EnvironmentPolicy = "alternate"
+	// PolicyRefuse refuses to create or validate licenses
+	PolicyRefuse EnvironmentPolicy = "refuse"
+)
+
+// ParseEnvironmentPolicy parses a policy name, case-insensitively
+func ParseEnvironmentPolicy(s string) (EnvironmentPolicy, bool) {
+	policy := EnvironmentPolicy(strings.ToLower(strings.TrimSpace(s)))
+	switch policy {
+	case PolicyAllow, PolicyWarn, PolicyAlternate, PolicyRefuse:
+		return policy, true
+	}
+	return "", false
 }
 
 // DefaultConfig returns the default configuration
@@ -23,6 +84,18 @@ func DefaultConfig() *Config {
 		DefaultMaxDays: 30,
 		LifetimeDays:   99999,
 		MasterKey:      "", // Will be set by environment or default
+
+		AllowLegacySerials: true,
+		GraceDays:          0,
+		WarnDays:           7,
+
+		HardwareMatchThreshold: 0,
+
+		ContainerPolicy: PolicyAllow,
+		VMPolicy:        PolicyAllow,
+
+		ClockSkew:         5 * time.Minute,
+		SystemTimeAnchors: true,
 	}
 }
 
@@ -43,24 +116,75 @@ func LoadConfig() *Config {
 		}
 	}
 
+	if allowLegacy := os.Getenv("LICENSE_ALLOW_LEGACY_SERIALS"); allowLegacy != "" {
+		if allow, err := strconv.ParseBool(allowLegacy); err == nil {
+			config.AllowLegacySerials = allow
+		}
+	}
+
+	if graceDays := os.Getenv("LICENSE_GRACE_DAYS"); graceDays != "" {
+		if days, err := strconv.Atoi(graceDays); err == nil && days >= 0 {
+			config.GraceDays = days
+		}
+	}
+
+	if warnDays := os.Getenv("LICENSE_WARN_DAYS"); warnDays != "" {
+		if days, err := strconv.Atoi(warnDays); err == nil && days >= 0 {
+			config.WarnDays = days
+		}
+	}
+
+	if threshold := os.Getenv("LICENSE_HARDWARE_MATCH"); threshold != "" {
+		if n, err := strconv.Atoi(threshold); err == nil && n >= 0 {
+			config.HardwareMatchThreshold = n
+		}
+	}
+
+	if policy, ok := ParseEnvironmentPolicy(os.Getenv("LICENSE_CONTAINER_POLICY")); ok {
+		config.ContainerPolicy = policy
+	}
+
+	if policy, ok := ParseEnvironmentPolicy(os.Getenv("LICENSE_VM_POLICY")); ok {
+		config.VMPolicy = policy
+	}
+
+	config.HostIDFile = os.Getenv("LICENSE_HOST_ID_FILE")
+
+	config.TimeServer = os.Getenv("LICENSE_TIME_SERVER")
+	config.TimestampFile = os.Getenv("LICENSE_TIMESTAMP_FILE")
+	config.TimestampKey = os.Getenv("LICENSE_TIMESTAMP_KEY")
+
+	if skew := os.Getenv("LICENSE_CLOCK_SKEW"); skew != "" {
+		if d, err := time.ParseDuration(skew); err == nil && d >= 0 {
+			config.ClockSkew = d
+		}
+	}
+
+	if anchors := os.Getenv("LICENSE_SYSTEM_TIME_ANCHORS"); anchors != "" {
+		if enabled, err := strconv.ParseBool(anchors); err == nil {
+			config.SystemTimeAnchors = enabled
+		}
+	}
+
 	// Master key is handled in crypto package, but we store the env var name here
 	config.MasterKey = os.Getenv("LICENSE_MASTER_KEY")
 
 	return config
 }
 
+// GetLicenseDir returns the directory from LICENSE_DIR or the current directory
+func (c *Config) GetLicenseDir() (string, error) {
+	if licenseDir := os.Getenv("LICENSE_DIR"); licenseDir != "" {
+		return licenseDir, nil
+	}
+	return os.Getwd()
+}
+
 // GetLicenseFilePathForProduct returns the license file path with product name
 func (c *Config) GetLicenseFilePathForProduct(productName string) (string, error) {
-	licenseDir := os.Getenv("LICENSE_DIR")
-	var dir string
-	var err error
-	if licenseDir != "" {
-		dir = licenseDir
-	} else {
-		dir, err = os.Getwd()
-		if err != nil {
-			return "", err
-		}
+	dir, err := c.GetLicenseDir()
+	if err != nil {
+		return "", err
 	}
 	// Sanitize product name for filename use
 	filename := sanitizeFilename(productName) + ".license"
@@ -68,32 +192,70 @@ func (c *Config) GetLicenseFilePathForProduct(productName string) (string, error
 	return f, nil
 }
 
+// GetActivationFilePathForProduct returns the path of the pending activation state for a product
+func (c *Config) GetActivationFilePathForProduct(productName string) (string, error) {
+	dir, err := c.GetLicenseDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sanitizeFilename(productName)+".activation"), nil
+}
+
+// GetStateDir returns the directory from LICENSE_STATE_DIR, or license-manager in the user
+// configuration directory. Usage records are kept out of the license directory so that wiping
+// or restoring it does not reset them.
+func (c *Config) GetStateDir() (string, error) {
+	if stateDir := os.Getenv("LICENSE_STATE_DIR"); stateDir != "" {
+		return stateDir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no user configuration directory, set LICENSE_STATE_DIR: %v", err)
+	}
+	return filepath.Join(dir, "license-manager"), nil
+}
+
+// GetStateFilePathForProduct returns the path of the file mirroring the usage generation
+// of a product's license
+func (c *Config) GetStateFilePathForProduct(productName string) (string, error) {
+	dir, err := c.GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sanitizeFilename(productName)+".state"), nil
+}
+
 // FindLicenseFile finds the first .license file in the license directory or current directory
 func (c *Config) FindLicenseFile() (string, error) {
-	licenseDir := os.Getenv("LICENSE_DIR")
-	var dir string
-	var err error
-	if licenseDir != "" {
-		dir = licenseDir
-	} else {
-		dir, err = os.Getwd()
-		if err != nil {
-			return "", err
-		}
+	files, err := c.ListLicenseFiles()
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", os.ErrNotExist
+	}
+	return files[0], nil
+}
+
+// ListLicenseFiles returns all .license files in the license directory or current directory
+func (c *Config) ListLicenseFiles() ([]string, error) {
+	dir, err := c.GetLicenseDir()
+	if err != nil {
+		return nil, err
 	}
 
 	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
+	var files []string
 	for _, entry := range entries {
 		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".license") {
-			return filepath.Join(dir, entry.Name()), nil
+			files = append(files, filepath.Join(dir, entry.Name()))
 		}
 	}
-
-	return "", os.ErrNotExist
+	return files, nil
 }
 
 // sanitizeFilename removes invalid characters from filename
@@ -155,9 +317,51 @@ func (c *Config) Validate() error {
 		return &ConfigError{Field: "LifetimeDays", Message: "must be positive"}
 	}
 
+	if c.GraceDays < 0 {
+		return &ConfigError{Field: "GraceDays", Message: "must not be negative"}
+	}
+
+	if c.WarnDays < 0 {
+		return &ConfigError{Field: "WarnDays", Message: "must not be negative"}
+	}
+
+	if c.HardwareMatchThreshold < 0 {
+		return &ConfigError{Field: "HardwareMatchThreshold", Message: "must not be negative"}
+	}
+
+	if err := c.validatePolicy("ContainerPolicy", c.ContainerPolicy); err != nil {
+		return err
+	}
+
+	if err := c.validatePolicy("VMPolicy", c.VMPolicy); err != nil {
+		return err
+	}
+
+	if c.ClockSkew < 0 {
+		return &ConfigError{Field: "ClockSkew", Message: "must not be negative"}
+	}
+
+	if c.TimestampFile != "" && c.TimestampKey == "" {
+		return &ConfigError{Field: "TimestampFile", Message: "requires TimestampKey"}
+	}
+
 	return nil
 }
 
+// validatePolicy checks an environment policy; empty means allow
+func (c *Config) validatePolicy(field string, policy EnvironmentPolicy) error {
+	switch policy {
+	case "", PolicyAllow, PolicyWarn, PolicyRefuse:
+		return nil
+	case PolicyAlternate:
+		if c.HostIDFile == "" {
+			return &ConfigError{Field: field, Message: "alternate requires HostIDFile"}
+		}
+		return nil
+	}
+	return &ConfigError{Field: field, Message: "must be allow, warn, alternate or refuse"}
+}
+
 // ConfigError represents a configuration validation error
 type ConfigError struct {
 	Field   string
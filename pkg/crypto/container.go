@@ -0,0 +1,201 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// License file container format.
+//
+// Every license file written by Seal starts with a self-describing header so the
+// crypto can evolve without guessing what a file contains. All integers are big-endian.
+//
+//	offset   size  field
+//	0        4     magic "LMLF"
+//	4        1     format version (currently 2)
+//	5        1     cipher suite (1 = AES-256-GCM)
+//	6        1     KDF algorithm (1 = PBKDF2-HMAC-SHA256, 2 = scrypt, 3 = Argon2id)
+//	7        1     key ID length n
+//	8        n     key ID (ASCII)
+//	8+n      2     KDF parameter length m
+//	10+n     m     KDF parameters (algorithm specific, see KDFParams)
+//	10+n+m   2     product name length p (version 2 and later)
+//	12+n+m   p     product name (UTF-8)
+//	...      ...   payload: nonce || ciphertext
+//
+// From version 2 on, every header byte is passed to AES-GCM as associated data.
+// Version 1 files have no product field and were sealed without associated data.
+// Files without the magic bytes are legacy headerless files (raw nonce || ciphertext)
+// and are reported as format version 0.
+
+// ContainerMagic identifies license container files
+var ContainerMagic = []byte("LMLF")
+
+const (
+	// FormatVersionLegacy is the headerless format written before containers existed
+	FormatVersionLegacy uint8 = 0
+	// FormatVersionAEAD is the first version that records the product and authenticates the header
+	FormatVersionAEAD uint8 = 2
+	// FormatVersionCurrent is the container version written by Seal
+	FormatVersionCurrent uint8 = FormatVersionAEAD
+)
+
+// CipherSuite identifies the payload encryption algorithm
+type CipherSuite uint8
+
+const (
+	// CipherAES256GCM is AES-256 in Galois/Counter Mode with a 12-byte nonce
+	CipherAES256GCM CipherSuite = 1
+)
+
+// String returns the display name of the cipher suite
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherAES256GCM:
+		return "AES-256-GCM"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+// Header describes how a license file payload was produced
+type Header struct {
+	Version     uint8
+	CipherSuite CipherSuite
+	KDF         KDFParams
+	KeyID       string
+	Product     string
+}
+
+// String returns a one-line description of the header for display
+func (h *Header) String() string {
+	if h.Version == FormatVersionLegacy {
+		return fmt.Sprintf("legacy headerless file (%s, %s)", h.CipherSuite, h.KDF)
+	}
+	if h.Product != "" {
+		return fmt.Sprintf("v%d (%s, %s, key %s, product %q)", h.Version, h.CipherSuite, h.KDF, h.KeyID, h.Product)
+	}
+	return fmt.Sprintf("v%d (%s, %s, key %s)", h.Version, h.CipherSuite, h.KDF, h.KeyID)
+}
+
+// AuthenticationError is returned when a license file fails AEAD authentication,
+// which means it was tampered with, encrypted under another key, or belongs to a
+// different product than the one it is opened for
+type AuthenticationError struct {
+	// Product the file was opened for, empty when not checked
+	Product string
+	// FileProduct recorded in the header, empty for older formats
+	FileProduct string
+	Err         error
+}
+
+func (e *AuthenticationError) Error() string {
+	if e.Product != "" && e.FileProduct != "" && e.Product != e.FileProduct {
+		return fmt.Sprintf("license file authentication failed: file is for product %q, not %q", e.FileProduct, e.Product)
+	}
+	return fmt.Sprintf("license file authentication failed: %v", e.Err)
+}
+
+func (e *AuthenticationError) Unwrap() error {
+	return e.Err
+}
+
+// UnsupportedVersionError is returned for containers newer than this library understands
+type UnsupportedVersionError struct {
+	Version uint8
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported license file format version %d (max supported %d)", e.Version, FormatVersionCurrent)
+}
+
+// MarshalContainer writes the header followed by the payload
+func MarshalContainer(h *Header, payload []byte) ([]byte, error) {
+	if len(h.KeyID) > 255 {
+		return nil, fmt.Errorf("key ID too long")
+	}
+	if len(h.Product) > 0xFFFF {
+		return nil, fmt.Errorf("product name too long")
+	}
+	params := h.KDF.marshal()
+
+	var buf bytes.Buffer
+	buf.Write(ContainerMagic)
+	buf.WriteByte(h.Version)
+	buf.WriteByte(byte(h.CipherSuite))
+	buf.WriteByte(byte(h.KDF.Algorithm))
+	buf.WriteByte(byte(len(h.KeyID)))
+	buf.WriteString(h.KeyID)
+	binary.Write(&buf, binary.BigEndian, uint16(len(params)))
+	buf.Write(params)
+	if h.Version >= FormatVersionAEAD {
+		binary.Write(&buf, binary.BigEndian, uint16(len(h.Product)))
+		buf.WriteString(h.Product)
+	}
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+// ParseContainer splits a license file into its header and payload.
+// Headerless legacy files are returned with a version 0 header describing the old defaults.
+func ParseContainer(data []byte) (*Header, []byte, error) {
+	if !bytes.HasPrefix(data, ContainerMagic) {
+		return &Header{
+			Version:     FormatVersionLegacy,
+			CipherSuite: CipherAES256GCM,
+			KDF:         LegacyKDFParams(),
+		}, data, nil
+	}
+
+	rest := data[len(ContainerMagic):]
+	if len(rest) < 4 {
+		return nil, nil, fmt.Errorf("truncated license file header")
+	}
+
+	version := rest[0]
+	if version == FormatVersionLegacy || version > FormatVersionCurrent {
+		return nil, nil, &UnsupportedVersionError{Version: version}
+	}
+
+	h := &Header{Version: version, CipherSuite: CipherSuite(rest[1])}
+	if h.CipherSuite != CipherAES256GCM {
+		return nil, nil, fmt.Errorf("unsupported cipher suite %d", rest[1])
+	}
+	kdfAlg := KDFAlgorithm(rest[2])
+
+	keyIDLen := int(rest[3])
+	rest = rest[4:]
+	if len(rest) < keyIDLen+2 {
+		return nil, nil, fmt.Errorf("truncated license file header")
+	}
+	h.KeyID = string(rest[:keyIDLen])
+	rest = rest[keyIDLen:]
+
+	paramsLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < paramsLen {
+		return nil, nil, fmt.Errorf("truncated license file header")
+	}
+	params, err := unmarshalKDFParams(kdfAlg, rest[:paramsLen])
+	if err != nil {
+		return nil, nil, err
+	}
+	h.KDF = params
+	rest = rest[paramsLen:]
+
+	if h.Version >= FormatVersionAEAD {
+		if len(rest) < 2 {
+			return nil, nil, fmt.Errorf("truncated license file header")
+		}
+		productLen := int(binary.BigEndian.Uint16(rest))
+		rest = rest[2:]
+		if len(rest) < productLen {
+			return nil, nil, fmt.Errorf("truncated license file header")
+		}
+		h.Product = string(rest[:productLen])
+		rest = rest[productLen:]
+	}
+
+	return h, rest, nil
+}
@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestSealOpenRoundTrip tests that sealed data carries a header and decrypts back
+func TestSealOpenRoundTrip(t *testing.T) {
+	cm, err := NewCryptoManagerWithKey("ContainerTestKey")
+	if err != nil {
+		t.Fatalf("Failed to create crypto manager: %v", err)
+	}
+
+	sealed, err := cm.Seal([]byte("payload"), "Product")
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	if !bytes.HasPrefix(sealed, ContainerMagic) {
+		t.Fatalf("Expected sealed data to start with container magic")
+	}
+
+	plaintext, header, err := cm.Open(sealed, "Product")
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	if string(plaintext) != "payload" {
+		t.Errorf("Expected payload, got %q", plaintext)
+	}
+	if header.Version != FormatVersionCurrent || header.KeyID != cm.KeyID() {
+		t.Errorf("Unexpected header: %s", header)
+	}
+}
+
+// TestOpenLegacyHeaderless tests that files written before containers existed still load
+func TestOpenLegacyHeaderless(t *testing.T) {
+	cm, err := NewCryptoManagerWithKey("ContainerTestKey")
+	if err != nil {
+		t.Fatalf("Failed to create crypto manager: %v", err)
+	}
+
+	legacy, err := cm.Encrypt([]byte("payload"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	plaintext, header, err := cm.Open(legacy, "Product")
+	if err != nil {
+		t.Fatalf("Failed to open legacy file: %v", err)
+	}
+	if string(plaintext) != "payload" {
+		t.Errorf("Expected payload, got %q", plaintext)
+	}
+	if header.Version != FormatVersionLegacy {
+		t.Errorf("Expected legacy version, got %d", header.Version)
+	}
+}
+
+// TestParseContainerUnknownVersion tests the error for containers from a newer release
+func TestParseContainerUnknownVersion(t *testing.T) {
+	data := append(append([]byte{}, ContainerMagic...), 99, 1, 1, 0, 0, 0)
+
+	_, _, err := ParseContainer(data)
+	var versionErr *UnsupportedVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("Expected UnsupportedVersionError, got %v", err)
+	}
+	if versionErr.Version != 99 {
+		t.Errorf("Expected version 99, got %d", versionErr.Version)
+	}
+}
+
+// TestOpenRejectsProductSwap tests that associated data binds the file to its product
+func TestOpenRejectsProductSwap(t *testing.T) {
+	cm, _ := NewCryptoManagerWithKey("ContainerTestKey")
+
+	sealed, err := cm.Seal([]byte("payload"), "Product A")
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	var authErr *AuthenticationError
+	if _, _, err := cm.Open(sealed, "Product B"); !errors.As(err, &authErr) {
+		t.Errorf("Expected AuthenticationError for wrong product, got %v", err)
+	}
+
+	// Editing the product in the header must break authentication
+	tampered := bytes.Replace(sealed, []byte("Product A"), []byte("Product B"), 1)
+	if _, _, err := cm.Open(tampered, "Product B"); !errors.As(err, &authErr) {
+		t.Errorf("Expected AuthenticationError for edited header, got %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
@@ -10,8 +12,6 @@ import (
 	"fmt"
 	"os"
 	"strings"
-
-	"golang.org/x/crypto/pbkdf2"
 )
 
 // CryptoManager handles all cryptographic operations with security-focused key derivation.
@@ -21,8 +21,16 @@ import (
 // - Deterministic key generation (same master key = same derived keys)
 // - Strong isolation between different key types
 // - Resistance to rainbow table attacks via unique salts
+//
+// Retired master keys can be added to a keyring so older files still open (see keyring.go),
+// and an Ed25519 key pair can be attached to sign licenses (see signing.go).
 type CryptoManager struct {
-	masterKey []byte
+	masterKey    []byte
+	keyring      map[string][]byte
+	kdf          KDFParams
+	cache        *keyCache
+	signingKey   ed25519.PrivateKey
+	verifyingKey ed25519.PublicKey
 }
 
 // NewCryptoManager creates a new crypto manager with a master key
@@ -34,7 +42,41 @@ func NewCryptoManager() (*CryptoManager, error) {
 	if len(key) != 32 {
 		return nil, fmt.Errorf("master key must be 32 bytes")
 	}
-	return &CryptoManager{masterKey: key}, nil
+	cm := newCryptoManager(key)
+
+	// KDF for new files; existing files keep the parameters recorded in their header
+	if kdfSpec := os.Getenv("LICENSE_KDF"); kdfSpec != "" {
+		params, err := ParseKDFParams(kdfSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LICENSE_KDF: %v", err)
+		}
+		cm.kdf = params
+	}
+
+	// Retired keys stay usable for decryption until every file has been rekeyed
+	if retired := os.Getenv("LICENSE_RETIRED_KEYS"); retired != "" {
+		for _, retiredKey := range strings.Split(retired, ",") {
+			if retiredKey = strings.TrimSpace(retiredKey); retiredKey != "" {
+				cm.AddRetiredKey(retiredKey)
+			}
+		}
+	}
+
+	// Asymmetric mode is enabled when a signing or public key is configured
+	if signingKey := os.Getenv("LICENSE_SIGNING_KEY"); signingKey != "" {
+		priv, err := ParsePrivateKey(signingKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LICENSE_SIGNING_KEY: %v", err)
+		}
+		cm.SetSigningKey(priv)
+	} else if publicKey := os.Getenv("LICENSE_PUBLIC_KEY"); publicKey != "" {
+		pub, err := ParsePublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LICENSE_PUBLIC_KEY: %v", err)
+		}
+		cm.SetVerifyingKey(pub)
+	}
+	return cm, nil
 }
 
 // NewCryptoManagerWithKey creates a new crypto manager with a provided master key
@@ -47,44 +89,108 @@ func NewCryptoManagerWithKey(masterKey string) (*CryptoManager, error) {
 	hash := sha256.Sum256([]byte(masterKey))
 	key := hash[:]
 
-	return &CryptoManager{masterKey: key}, nil
+	return newCryptoManager(key), nil
+}
+
+// newCryptoManager creates a crypto manager whose keyring holds only the active key
+func newCryptoManager(key []byte) *CryptoManager {
+	cm := &CryptoManager{
+		masterKey: key,
+		keyring:   make(map[string][]byte),
+		kdf:       DefaultKDFParams(),
+		cache:     newKeyCache(),
+	}
+	cm.keyring[cm.KeyID()] = key
+	return cm
 }
 
-// getMasterKey retrieves the master key from environment variable
+// getMasterKey retrieves the master key from the configured key provider
 func getMasterKey() ([]byte, error) {
-	// Get key from environment variable (required)
-	envKey := os.Getenv("LICENSE_MASTER_KEY")
-	if envKey == "" {
-		return nil, fmt.Errorf("LICENSE_MASTER_KEY environment variable is required")
+	provider, err := DefaultKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey, err := provider.MasterKey()
+	if err != nil {
+		return nil, err
 	}
 
 	// Ensure key is exactly 32 bytes by hashing it
-	hash := sha256.Sum256([]byte(envKey))
+	hash := sha256.Sum256([]byte(masterKey))
 	return hash[:], nil
 }
 
+// NewCryptoManagerWithProvider creates a new crypto manager with a master key from a key provider
+func NewCryptoManagerWithProvider(provider KeyProvider) (*CryptoManager, error) {
+	masterKey, err := provider.MasterKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master key: %v", err)
+	}
+	return NewCryptoManagerWithKey(masterKey)
+}
+
 // DeriveSerialKey derives a key for serial generation from the master key.
-// Uses PBKDF2 with 10,000 iterations for computational cost against brute force attacks.
-// The salt is deterministically derived from the master key to ensure consistency.
-func (cm *CryptoManager) DeriveSerialKey() []byte {
-	// Use PBKDF2 with a unique salt for serial key derivation
-	salt := cm.deriveSalt("SERIAL_KEY_DERIVATION")
-	return pbkdf2.Key(cm.masterKey, salt, 10000, 32, sha256.New)
+// Uses the configured KDF (PBKDF2 with 10,000 iterations by default) for computational
+// cost against brute force attacks. The salt is deterministically derived from the master key.
+func (cm *CryptoManager) DeriveSerialKey() ([]byte, error) {
+	return cm.deriveKey("SERIAL_KEY_DERIVATION", cm.kdf)
 }
 
 // DeriveEncryptionKey derives a key for encryption from the master key.
-// Uses PBKDF2 with 10,000 iterations and a unique salt for AES-GCM encryption.
+// Uses the configured KDF and a unique salt for AES-GCM encryption.
 // This ensures the encryption key is completely different from the serial key.
-func (cm *CryptoManager) DeriveEncryptionKey() []byte {
-	// Use PBKDF2 with a unique salt for encryption key derivation
-	salt := cm.deriveSalt("ENCRYPTION_KEY_DERIVATION")
-	return pbkdf2.Key(cm.masterKey, salt, 10000, 32, sha256.New)
+func (cm *CryptoManager) DeriveEncryptionKey() ([]byte, error) {
+	return cm.deriveKey("ENCRYPTION_KEY_DERIVATION", cm.kdf)
 }
 
-// GenerateSerial creates a serial number using the same logic as before
-// but with derived key instead of hardcoded secret
-func (cm *CryptoManager) GenerateSerial(pcId, productName string, maxDays int) string {
-	serialKey := cm.DeriveSerialKey()
+// SetKDF selects the key derivation parameters used for new license files
+func (cm *CryptoManager) SetKDF(params KDFParams) error {
+	if err := params.validate(); err != nil {
+		return err
+	}
+	cm.kdf = params
+	return nil
+}
+
+// KDF returns the key derivation parameters used for new license files
+func (cm *CryptoManager) KDF() KDFParams {
+	return cm.kdf
+}
+
+// deriveKey runs the KDF described by params with a unique salt for the given purpose.
+// Results are cached for the lifetime of the manager, so memory-hard KDFs only slow down
+// the first derivation and offline brute force, not every validation.
+func (cm *CryptoManager) deriveKey(purpose string, params KDFParams) ([]byte, error) {
+	if err := params.validate(); err != nil {
+		return nil, fmt.Errorf("key derivation failed: %v", err)
+	}
+	cacheID := fmt.Sprintf("%s|%s|%d|%d|%d|%d|%d", cm.KeyID(), purpose,
+		params.Algorithm, params.Iterations, params.Memory, params.Parallelism, params.BlockSize)
+
+	key, err := cm.cache.get(cacheID, func() ([]byte, error) {
+		return runKDF(cm.masterKey, cm.deriveSalt(purpose), params)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("key derivation failed: %v", err)
+	}
+	return key, nil
+}
+
+// KeyID returns a short public identifier of the master key.
+// It is recorded in license file headers and reveals nothing about the key itself.
+func (cm *CryptoManager) KeyID() string {
+	return hex.EncodeToString(cm.deriveSalt("KEY_IDENTIFIER")[:4])
+}
+
+// GenerateSerial creates a legacy serial number using the same logic as before
+// but with derived key instead of hardcoded secret.
+// Deprecated: it only covers PC ID, product and max days; use GenerateAuthenticator.
+func (cm *CryptoManager) GenerateSerial(pcId, productName string, maxDays int) (string, error) {
+	serialKey, err := cm.deriveKey("SERIAL_KEY_DERIVATION", LegacyKDFParams())
+	if err != nil {
+		return "", err
+	}
 	serialKeyHex := hex.EncodeToString(serialKey)
 
 	// Keep the exact same logic as before to maintain compatibility
@@ -99,13 +205,176 @@ func (cm *CryptoManager) GenerateSerial(pcId, productName string, maxDays int) s
 		}
 		serial += string(char)
 	}
-	return strings.ToUpper(serial[:23])
+	return strings.ToUpper(serial[:23]), nil
+}
+
+// SerialV2Prefix is the version marker of serials produced by GenerateAuthenticator.
+// Serials without it are legacy MD5-based serials from GenerateSerial.
+const SerialV2Prefix = "V2-"
+
+// GenerateAuthenticator creates a versioned serial as HMAC-SHA256 over the full
+// canonical license payload, so every issued field is covered by the serial check
+func (cm *CryptoManager) GenerateAuthenticator(payload []byte) (string, error) {
+	serialKey, err := cm.DeriveSerialKey()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, serialKey)
+	mac.Write(payload)
+	return SerialV2Prefix + strings.ToUpper(hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// VerifyAuthenticator checks a versioned serial against the payload in constant time.
+// A serial cannot be verified, and is rejected, when the serial key cannot be derived.
+func (cm *CryptoManager) VerifyAuthenticator(serial string, payload []byte) bool {
+	if !strings.HasPrefix(serial, SerialV2Prefix) {
+		return false
+	}
+	expected, err := cm.GenerateAuthenticator(payload)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(serial), []byte(expected))
+}
+
+// DeriveActivationKey derives the key that authenticates offline activation requests.
+// It always uses the default KDF so that client and issuing machines agree on the key
+// even when they are configured with different LICENSE_KDF settings.
+func (cm *CryptoManager) DeriveActivationKey() ([]byte, error) {
+	return cm.deriveKey("ACTIVATION_KEY_DERIVATION", DefaultKDFParams())
+}
+
+// ActivationMAC returns the HMAC-SHA256 of an activation request payload as hex
+func (cm *CryptoManager) ActivationMAC(payload []byte) (string, error) {
+	activationKey, err := cm.DeriveActivationKey()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, activationKey)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifyActivationMAC checks an activation request MAC in constant time
+func (cm *CryptoManager) VerifyActivationMAC(mac string, payload []byte) bool {
+	expected, err := cm.ActivationMAC(payload)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(expected))
+}
+
+// IsLegacySerial reports whether a serial predates the versioned HMAC scheme
+func IsLegacySerial(serial string) bool {
+	return !strings.HasPrefix(serial, SerialV2Prefix)
 }
 
 // Encrypt encrypts data using AES-GCM with derived encryption key
 func (cm *CryptoManager) Encrypt(data []byte) ([]byte, error) {
-	key := cm.DeriveEncryptionKey()
+	key, err := cm.DeriveEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return encryptGCM(key, data, nil)
+}
+
+// Decrypt decrypts data using AES-GCM with derived encryption key
+func (cm *CryptoManager) Decrypt(data []byte) ([]byte, error) {
+	key, err := cm.DeriveEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return decryptGCM(key, data, nil)
+}
+
+// Seal encrypts data for a product and wraps it in a versioned license container (see container.go).
+// The complete header, including product name, format version and key ID, is authenticated
+// as AEAD associated data, so a file cannot be renamed to another product or have its header edited.
+func (cm *CryptoManager) Seal(data []byte, product string) ([]byte, error) {
+	header := &Header{
+		Version:     FormatVersionCurrent,
+		CipherSuite: CipherAES256GCM,
+		KDF:         cm.kdf,
+		KeyID:       cm.KeyID(),
+		Product:     product,
+	}
+
+	headerBytes, err := MarshalContainer(header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := cm.DeriveEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encryptGCM(key, data, headerBytes)
+	if err != nil {
+		return nil, err
+	}
+	return append(headerBytes, payload...), nil
+}
+
+// Open parses a license container, or a legacy headerless file, and decrypts its payload.
+// The file may be encrypted under any key in the keyring. The returned header always names
+// the key that decrypted the payload, which for legacy files is found by trial.
+// When product is not empty, files recording a different product are rejected.
+// Authentication failures are reported as *AuthenticationError.
+func (cm *CryptoManager) Open(data []byte, product string) ([]byte, *Header, error) {
+	header, payload, err := ParseContainer(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if product != "" && header.Product != "" && header.Product != product {
+		return nil, header, &AuthenticationError{
+			Product:     product,
+			FileProduct: header.Product,
+			Err:         fmt.Errorf("license file belongs to product %q", header.Product),
+		}
+	}
+
+	// Older formats were sealed without associated data
+	var associatedData []byte
+	if header.Version >= FormatVersionAEAD {
+		associatedData = data[:len(data)-len(payload)]
+	}
 
+	if header.KeyID != "" {
+		keyMgr, err := cm.ForKey(header.KeyID)
+		if err != nil {
+			return nil, header, err
+		}
+		key, err := keyMgr.deriveKey("ENCRYPTION_KEY_DERIVATION", header.KDF)
+		if err != nil {
+			return nil, header, err
+		}
+		plaintext, err := decryptGCM(key, payload, associatedData)
+		if err != nil {
+			return nil, header, &AuthenticationError{Product: product, FileProduct: header.Product, Err: err}
+		}
+		return plaintext, header, nil
+	}
+
+	// Legacy files carry no key ID, so try the active key first and then retired ones
+	var plaintext, key []byte
+	for _, keyID := range cm.KeyIDs() {
+		keyMgr, _ := cm.ForKey(keyID)
+		key, err = keyMgr.deriveKey("ENCRYPTION_KEY_DERIVATION", header.KDF)
+		if err != nil {
+			return nil, header, err
+		}
+		plaintext, err = decryptGCM(key, payload, nil)
+		if err == nil {
+			header.KeyID = keyID
+			return plaintext, header, nil
+		}
+	}
+	return nil, header, &AuthenticationError{Product: product, Err: err}
+}
+
+// encryptGCM encrypts data with AES-GCM and prepends the random nonce
+func encryptGCM(key, data, associatedData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -121,14 +390,12 @@ func (cm *CryptoManager) Encrypt(data []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
+	ciphertext := gcm.Seal(nonce, nonce, data, associatedData)
 	return ciphertext, nil
 }
 
-// Decrypt decrypts data using AES-GCM with derived encryption key
-func (cm *CryptoManager) Decrypt(data []byte) ([]byte, error) {
-	key := cm.DeriveEncryptionKey()
-
+// decryptGCM decrypts nonce||ciphertext produced by encryptGCM
+func decryptGCM(key, data, associatedData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -145,7 +412,7 @@ func (cm *CryptoManager) Decrypt(data []byte) ([]byte, error) {
 	}
 
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, associatedData)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,275 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDFAlgorithm identifies the key derivation function used for the file keys
+type KDFAlgorithm uint8
+
+const (
+	// KDFPBKDF2SHA256 is PBKDF2 with HMAC-SHA256
+	KDFPBKDF2SHA256 KDFAlgorithm = 1
+	// KDFScrypt is the memory-hard scrypt function
+	KDFScrypt KDFAlgorithm = 2
+	// KDFArgon2id is the memory-hard Argon2id function
+	KDFArgon2id KDFAlgorithm = 3
+)
+
+// String returns the display name of the KDF algorithm
+func (k KDFAlgorithm) String() string {
+	switch k {
+	case KDFPBKDF2SHA256:
+		return "PBKDF2-SHA256"
+	case KDFScrypt:
+		return "scrypt"
+	case KDFArgon2id:
+		return "Argon2id"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(k))
+	}
+}
+
+// KDFParams holds the key derivation parameters recorded in a container.
+// Which fields are used depends on the algorithm:
+//   - PBKDF2:   Iterations
+//   - scrypt:   Memory is the cost N (power of two), BlockSize is r, Parallelism is p
+//   - Argon2id: Iterations is the time cost, Memory is in KiB, Parallelism is the thread count
+type KDFParams struct {
+	Algorithm   KDFAlgorithm
+	Iterations  uint32
+	Memory      uint32
+	Parallelism uint32
+	BlockSize   uint32
+}
+
+// Upper bounds for parameters read from license files, so a crafted header
+// cannot make validation consume unbounded CPU or memory
+const (
+	maxPBKDF2Iterations = 10_000_000
+	maxScryptN          = 1 << 20
+	maxScryptR          = 32
+	maxScryptP          = 16
+	maxArgon2Time       = 64
+	// maxKDFMemory bounds the memory a single derivation may use, in bytes
+	maxKDFMemory       = 1 << 30
+	maxArgon2MemoryKiB = maxKDFMemory / 1024
+)
+
+// LegacyKDFParams returns the parameters of headerless files and legacy serials
+func LegacyKDFParams() KDFParams {
+	return KDFParams{Algorithm: KDFPBKDF2SHA256, Iterations: 10000}
+}
+
+// DefaultKDFParams returns the parameters used for new license files unless configured otherwise
+func DefaultKDFParams() KDFParams {
+	return LegacyKDFParams()
+}
+
+// ScryptKDFParams returns recommended scrypt parameters (N=32768, r=8, p=1)
+func ScryptKDFParams() KDFParams {
+	return KDFParams{Algorithm: KDFScrypt, Memory: 1 << 15, BlockSize: 8, Parallelism: 1}
+}
+
+// Argon2idKDFParams returns recommended Argon2id parameters (t=3, m=64 MiB, p=4)
+func Argon2idKDFParams() KDFParams {
+	return KDFParams{Algorithm: KDFArgon2id, Iterations: 3, Memory: 64 * 1024, Parallelism: 4}
+}
+
+// ParseKDFParams parses a KDF specification such as "pbkdf2:i=600000",
+// "scrypt:n=32768,r=8,p=1" or "argon2id:t=3,m=65536,p=4".
+// Omitted parameters take the recommended defaults of the algorithm.
+func ParseKDFParams(spec string) (KDFParams, error) {
+	name, args, _ := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), ":")
+
+	var params KDFParams
+	switch name {
+	case "pbkdf2":
+		params = LegacyKDFParams()
+	case "scrypt":
+		params = ScryptKDFParams()
+	case "argon2id":
+		params = Argon2idKDFParams()
+	default:
+		return KDFParams{}, fmt.Errorf("unknown KDF %q", name)
+	}
+
+	if args != "" {
+		for _, pair := range strings.Split(args, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return KDFParams{}, fmt.Errorf("invalid KDF parameter %q", pair)
+			}
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return KDFParams{}, fmt.Errorf("invalid KDF parameter %q: %v", pair, err)
+			}
+			switch key {
+			case "i", "t":
+				params.Iterations = uint32(n)
+			case "m", "n":
+				params.Memory = uint32(n)
+			case "p":
+				params.Parallelism = uint32(n)
+			case "r":
+				params.BlockSize = uint32(n)
+			default:
+				return KDFParams{}, fmt.Errorf("unknown KDF parameter %q", key)
+			}
+		}
+	}
+
+	if err := params.validate(); err != nil {
+		return KDFParams{}, err
+	}
+	return params, nil
+}
+
+// String returns a human-readable description of the parameters
+func (p KDFParams) String() string {
+	switch p.Algorithm {
+	case KDFScrypt:
+		return fmt.Sprintf("%s (N=%d, r=%d, p=%d)", p.Algorithm, p.Memory, p.BlockSize, p.Parallelism)
+	case KDFArgon2id:
+		return fmt.Sprintf("%s (t=%d, m=%dKiB, p=%d)", p.Algorithm, p.Iterations, p.Memory, p.Parallelism)
+	default:
+		return fmt.Sprintf("%s (iterations=%d)", p.Algorithm, p.Iterations)
+	}
+}
+
+// validate rejects parameters the KDF cannot run with
+func (p KDFParams) validate() error {
+	switch p.Algorithm {
+	case KDFPBKDF2SHA256:
+		if p.Iterations == 0 || p.Iterations > maxPBKDF2Iterations {
+			return fmt.Errorf("PBKDF2 iterations must be between 1 and %d", maxPBKDF2Iterations)
+		}
+	case KDFScrypt:
+		if p.Memory < 2 || p.Memory > maxScryptN || p.Memory&(p.Memory-1) != 0 {
+			return fmt.Errorf("scrypt N must be a power of two between 2 and %d", maxScryptN)
+		}
+		if p.BlockSize == 0 || p.BlockSize > maxScryptR {
+			return fmt.Errorf("scrypt r must be between 1 and %d", maxScryptR)
+		}
+		if p.Parallelism == 0 || p.Parallelism > maxScryptP {
+			return fmt.Errorf("scrypt p must be between 1 and %d", maxScryptP)
+		}
+		// scrypt allocates 128*N*r bytes
+		if 128*uint64(p.Memory)*uint64(p.BlockSize) > maxKDFMemory {
+			return fmt.Errorf("scrypt N=%d, r=%d would use more than %d MiB", p.Memory, p.BlockSize, maxKDFMemory>>20)
+		}
+	case KDFArgon2id:
+		if p.Iterations == 0 || p.Iterations > maxArgon2Time {
+			return fmt.Errorf("Argon2id time must be between 1 and %d", maxArgon2Time)
+		}
+		if p.Memory == 0 || p.Memory > maxArgon2MemoryKiB {
+			return fmt.Errorf("Argon2id memory must be between 1 and %d KiB", maxArgon2MemoryKiB)
+		}
+		if p.Parallelism == 0 || p.Parallelism > 255 {
+			return fmt.Errorf("Argon2id parallelism must be between 1 and 255")
+		}
+	default:
+		return fmt.Errorf("unsupported KDF algorithm %d", uint8(p.Algorithm))
+	}
+	return nil
+}
+
+// marshal encodes the algorithm specific parameters
+func (p KDFParams) marshal() []byte {
+	var fields []uint32
+	switch p.Algorithm {
+	case KDFScrypt:
+		fields = []uint32{p.Memory, p.BlockSize, p.Parallelism}
+	case KDFArgon2id:
+		fields = []uint32{p.Iterations, p.Memory, p.Parallelism}
+	default:
+		fields = []uint32{p.Iterations}
+	}
+
+	buf := make([]byte, 4*len(fields))
+	for i, field := range fields {
+		binary.BigEndian.PutUint32(buf[4*i:], field)
+	}
+	return buf
+}
+
+// unmarshalKDFParams decodes algorithm specific parameters
+func unmarshalKDFParams(alg KDFAlgorithm, data []byte) (KDFParams, error) {
+	field := func(i int) uint32 { return binary.BigEndian.Uint32(data[4*i:]) }
+
+	var params KDFParams
+	switch alg {
+	case KDFPBKDF2SHA256:
+		if len(data) != 4 {
+			return KDFParams{}, fmt.Errorf("invalid PBKDF2 parameters")
+		}
+		params = KDFParams{Algorithm: alg, Iterations: field(0)}
+	case KDFScrypt:
+		if len(data) != 12 {
+			return KDFParams{}, fmt.Errorf("invalid scrypt parameters")
+		}
+		params = KDFParams{Algorithm: alg, Memory: field(0), BlockSize: field(1), Parallelism: field(2)}
+	case KDFArgon2id:
+		if len(data) != 12 {
+			return KDFParams{}, fmt.Errorf("invalid Argon2id parameters")
+		}
+		params = KDFParams{Algorithm: alg, Iterations: field(0), Memory: field(1), Parallelism: field(2)}
+	default:
+		return KDFParams{}, fmt.Errorf("unsupported KDF algorithm %d", uint8(alg))
+	}
+
+	if err := params.validate(); err != nil {
+		return KDFParams{}, err
+	}
+	return params, nil
+}
+
+// runKDF derives a 32-byte key from the master key and salt
+func runKDF(masterKey, salt []byte, params KDFParams) ([]byte, error) {
+	switch params.Algorithm {
+	case KDFPBKDF2SHA256:
+		return pbkdf2.Key(masterKey, salt, int(params.Iterations), 32, sha256.New), nil
+	case KDFScrypt:
+		return scrypt.Key(masterKey, salt, int(params.Memory), int(params.BlockSize), int(params.Parallelism), 32)
+	case KDFArgon2id:
+		return argon2.IDKey(masterKey, salt, params.Iterations, params.Memory, uint8(params.Parallelism), 32), nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF algorithm %d", uint8(params.Algorithm))
+	}
+}
+
+// keyCache keeps derived keys for the lifetime of a crypto manager so the
+// expensive KDF runs once per key, purpose and parameter set
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func newKeyCache() *keyCache {
+	return &keyCache{keys: make(map[string][]byte)}
+}
+
+// get returns the cached key or derives and stores it
+func (c *keyCache) get(id string, derive func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[id]; ok {
+		return key, nil
+	}
+	key, err := derive()
+	if err != nil {
+		return nil, err
+	}
+	c.keys[id] = key
+	return key, nil
+}
@@ -0,0 +1,95 @@
+package crypto
+
+import "testing"
+
+// TestParseKDFParams tests KDF specification parsing and defaults
+func TestParseKDFParams(t *testing.T) {
+	params, err := ParseKDFParams("argon2id:t=2,m=1024")
+	if err != nil {
+		t.Fatalf("Failed to parse Argon2id spec: %v", err)
+	}
+	if params.Algorithm != KDFArgon2id || params.Iterations != 2 || params.Memory != 1024 || params.Parallelism != 4 {
+		t.Errorf("Unexpected Argon2id params: %s", params)
+	}
+
+	if _, err := ParseKDFParams("scrypt:n=1000"); err == nil {
+		t.Errorf("Expected non power of two scrypt N to be rejected")
+	}
+	if _, err := ParseKDFParams("bcrypt"); err == nil {
+		t.Errorf("Expected unknown KDF to be rejected")
+	}
+}
+
+// TestSealRecordsKDF tests that memory-hard KDF parameters round-trip through the header
+func TestSealRecordsKDF(t *testing.T) {
+	for _, spec := range []string{"scrypt:n=1024,r=8,p=1", "argon2id:t=1,m=1024,p=1"} {
+		writer, _ := NewCryptoManagerWithKey("KDFTestKey")
+		params, err := ParseKDFParams(spec)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", spec, err)
+		}
+		if err := writer.SetKDF(params); err != nil {
+			t.Fatalf("Failed to set KDF: %v", err)
+		}
+
+		sealed, err := writer.Seal([]byte("payload"), "Product")
+		if err != nil {
+			t.Fatalf("Failed to seal with %s: %v", spec, err)
+		}
+
+		// A reader configured with the default KDF follows the header
+		reader, _ := NewCryptoManagerWithKey("KDFTestKey")
+		plaintext, header, err := reader.Open(sealed, "Product")
+		if err != nil {
+			t.Fatalf("Failed to open %s file: %v", spec, err)
+		}
+		if string(plaintext) != "payload" {
+			t.Errorf("Expected payload, got %q", plaintext)
+		}
+		if header.KDF != params {
+			t.Errorf("Expected header KDF %s, got %s", params, header.KDF)
+		}
+	}
+}
+
+// TestDerivedKeysCached tests that derived keys are computed once per manager
+func TestDerivedKeysCached(t *testing.T) {
+	cm, _ := NewCryptoManagerWithKey("KDFTestKey")
+
+	first, err := cm.DeriveEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to derive encryption key: %v", err)
+	}
+	second, _ := cm.DeriveEncryptionKey()
+	if &first[0] != &second[0] {
+		t.Errorf("Expected cached encryption key to be reused")
+	}
+	if serialKey, _ := cm.DeriveSerialKey(); string(serialKey) == string(first) {
+		t.Errorf("Expected serial and encryption keys to differ")
+	}
+}
+
+// TestKDFParamsBounded tests that parameters which would use excessive memory are rejected
+func TestKDFParamsBounded(t *testing.T) {
+	for _, spec := range []string{
+		"scrypt:n=2097152,r=1,p=1",
+		"scrypt:n=1024,r=33,p=1",
+		"scrypt:n=1024,r=8,p=17",
+		"scrypt:n=1048576,r=16,p=1",
+		"argon2id:t=1,m=2097152,p=1",
+	} {
+		if _, err := ParseKDFParams(spec); err == nil {
+			t.Errorf("Expected %s to be rejected", spec)
+		}
+	}
+	if _, err := ParseKDFParams("scrypt:n=1048576,r=8,p=1"); err != nil {
+		t.Errorf("Expected 1 GiB of scrypt memory to be accepted: %v", err)
+	}
+
+	// Derivation reports parameters that bypassed validation instead of panicking
+	writer, _ := NewCryptoManagerWithKey("KDFTestKey")
+	writer.kdf = KDFParams{Algorithm: KDFScrypt, Memory: 1 << 20, BlockSize: 1024, Parallelism: 1}
+	if _, err := writer.Seal([]byte("payload"), "Product"); err == nil {
+		t.Errorf("Expected sealing with oversized scrypt parameters to fail")
+	}
+}
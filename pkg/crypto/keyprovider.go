@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// KeyProvider supplies the master key material.
+// Applications can implement it to source the key from their own secret store.
+type KeyProvider interface {
+	MasterKey() (string, error)
+}
+
+// DefaultKeyProvider returns the provider selected by LICENSE_KEY_PROVIDER,
+// or the LICENSE_MASTER_KEY environment variable when it is not set.
+func DefaultKeyProvider() (KeyProvider, error) {
+	spec := os.Getenv("LICENSE_KEY_PROVIDER")
+	if spec == "" {
+		return &EnvKeyProvider{}, nil
+	}
+	return ParseKeyProvider(spec)
+}
+
+// ParseKeyProvider creates a built-in provider from a specification string:
+//
+//	env[:NAME]           read the key from an environment variable (default LICENSE_MASTER_KEY)
+//	file:PATH            read the key from a file that only its owner can access
+//	prompt               ask for the key on the terminal or read it from stdin
+//	command:PROGRAM ARGS run a helper program and read the key from its output
+func ParseKeyProvider(spec string) (KeyProvider, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch kind {
+	case "env":
+		return &EnvKeyProvider{Name: arg}, nil
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("file key provider requires a path")
+		}
+		return &FileKeyProvider{Path: arg}, nil
+	case "prompt":
+		return &PromptKeyProvider{}, nil
+	case "command":
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("command key provider requires a program")
+		}
+		return &CommandKeyProvider{Command: fields[0], Args: fields[1:]}, nil
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", kind)
+	}
+}
+
+// EnvKeyProvider reads the master key from an environment variable
+type EnvKeyProvider struct {
+	// Name of the variable, LICENSE_MASTER_KEY when empty
+	Name string
+}
+
+// MasterKey returns the value of the environment variable
+func (p *EnvKeyProvider) MasterKey() (string, error) {
+	name := p.Name
+	if name == "" {
+		name = "LICENSE_MASTER_KEY"
+	}
+	key := os.Getenv(name)
+	if key == "" {
+		return "", fmt.Errorf("%s environment variable is required", name)
+	}
+	return key, nil
+}
+
+// FileKeyProvider reads the master key from a file.
+// On Unix the file must not be accessible by group or others.
+type FileKeyProvider struct {
+	Path string
+	// AllowInsecurePermissions skips the permission check
+	AllowInsecurePermissions bool
+}
+
+// MasterKey returns the trimmed contents of the key file
+func (p *FileKeyProvider) MasterKey() (string, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to access key file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("key file %s is not a regular file", p.Path)
+	}
+	if runtime.GOOS != "windows" && !p.AllowInsecurePermissions && info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("key file %s has insecure permissions %04o (expected 0600 or stricter)", p.Path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %v", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("key file %s is empty", p.Path)
+	}
+	return key, nil
+}
+
+// PromptKeyProvider asks for the master key interactively.
+// Input is not echoed when reading from a terminal.
+type PromptKeyProvider struct {
+	// Prompt text, "Master key: " when empty
+	Prompt string
+	// In defaults to os.Stdin, Out defaults to os.Stderr
+	In  io.Reader
+	Out io.Writer
+}
+
+// MasterKey prompts for and returns the key
+func (p *PromptKeyProvider) MasterKey() (string, error) {
+	in := p.In
+	if in == nil {
+		in = os.Stdin
+	}
+	out := p.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	prompt := p.Prompt
+	if prompt == "" {
+		prompt = "Master key: "
+	}
+
+	fmt.Fprint(out, prompt)
+
+	var key string
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		raw, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return "", fmt.Errorf("failed to read master key: %v", err)
+		}
+		key = string(raw)
+	} else {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read master key: %v", err)
+		}
+		key = line
+	}
+
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("no master key entered")
+	}
+	return key, nil
+}
+
+// CommandKeyProvider runs an external helper program, similar to git credential helpers.
+// The first line the helper writes to stdout is used as the master key.
+type CommandKeyProvider struct {
+	Command string
+	Args    []string
+	// Timeout for the helper, 30 seconds when zero
+	Timeout time.Duration
+}
+
+// MasterKey runs the helper and returns its output
+func (p *CommandKeyProvider) MasterKey() (string, error) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("key helper %s failed: %v: %s", p.Command, err, msg)
+		}
+		return "", fmt.Errorf("key helper %s failed: %v", p.Command, err)
+	}
+
+	key, _, _ := strings.Cut(string(output), "\n")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("key helper %s returned no key", p.Command)
+	}
+	return key, nil
+}
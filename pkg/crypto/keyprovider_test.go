@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestFileKeyProviderPermissions tests that world-readable key files are refused
+func TestFileKeyProviderPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission checks are Unix only")
+	}
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte("FileProviderKey\n"), 0644); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	provider := &FileKeyProvider{Path: path}
+	if _, err := provider.MasterKey(); err == nil {
+		t.Errorf("Expected insecure key file to be refused")
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatalf("Failed to chmod key file: %v", err)
+	}
+	key, err := provider.MasterKey()
+	if err != nil {
+		t.Fatalf("Failed to read key file: %v", err)
+	}
+	if key != "FileProviderKey" {
+		t.Errorf("Expected FileProviderKey, got %q", key)
+	}
+}
+
+// TestPromptKeyProviderPiped tests reading the key from non-terminal input
+func TestPromptKeyProviderPiped(t *testing.T) {
+	var out strings.Builder
+	provider := &PromptKeyProvider{In: strings.NewReader("PipedKey\n"), Out: &out}
+
+	key, err := provider.MasterKey()
+	if err != nil {
+		t.Fatalf("Failed to read key: %v", err)
+	}
+	if key != "PipedKey" {
+		t.Errorf("Expected PipedKey, got %q", key)
+	}
+	if !strings.Contains(out.String(), "Master key") {
+		t.Errorf("Expected prompt to be written, got %q", out.String())
+	}
+}
+
+// TestParseKeyProviderCommand tests the command helper provider
+func TestParseKeyProviderCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses echo")
+	}
+
+	provider, err := ParseKeyProvider("command:echo HelperKey")
+	if err != nil {
+		t.Fatalf("Failed to parse provider: %v", err)
+	}
+	key, err := provider.MasterKey()
+	if err != nil {
+		t.Fatalf("Failed to run helper: %v", err)
+	}
+	if key != "HelperKey" {
+		t.Errorf("Expected HelperKey, got %q", key)
+	}
+
+	if _, err := ParseKeyProvider("vault:secret"); err == nil {
+		t.Errorf("Expected unknown provider to be rejected")
+	}
+}
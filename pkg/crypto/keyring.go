@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+)
+
+// Key rotation.
+// A crypto manager always encrypts and authenticates with its active master key,
+// but can decrypt and verify with any retired key added to its keyring. Keys are
+// identified by KeyID, which is recorded in every license file header.
+
+// NewCryptoManagerWithKeyring creates a crypto manager with an active key and retired keys
+func NewCryptoManagerWithKeyring(activeKey string, retiredKeys ...string) (*CryptoManager, error) {
+	cm, err := NewCryptoManagerWithKey(activeKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range retiredKeys {
+		if key == "" {
+			return nil, fmt.Errorf("retired master key cannot be empty")
+		}
+		cm.AddRetiredKey(key)
+	}
+	return cm, nil
+}
+
+// AddRetiredKey adds a master key that may only be used to open existing files.
+// It returns the key ID of the added key.
+func (cm *CryptoManager) AddRetiredKey(masterKey string) string {
+	hash := sha256.Sum256([]byte(masterKey))
+	retired := &CryptoManager{masterKey: hash[:]}
+	keyID := retired.KeyID()
+	if _, exists := cm.keyring[keyID]; !exists {
+		cm.keyring[keyID] = retired.masterKey
+	}
+	return keyID
+}
+
+// KeyIDs returns all known key IDs, active key first and retired keys sorted
+func (cm *CryptoManager) KeyIDs() []string {
+	active := cm.KeyID()
+	ids := []string{active}
+	var retired []string
+	for id := range cm.keyring {
+		if id != active {
+			retired = append(retired, id)
+		}
+	}
+	slices.Sort(retired)
+	return append(ids, retired...)
+}
+
+// ForKey returns a crypto manager that uses the given keyring entry as its master key.
+// Signing keys are shared with the original manager.
+func (cm *CryptoManager) ForKey(keyID string) (*CryptoManager, error) {
+	if keyID == cm.KeyID() {
+		return cm, nil
+	}
+	key, ok := cm.keyring[keyID]
+	if !ok {
+		return nil, fmt.Errorf("license file was encrypted with unknown key ID %s", keyID)
+	}
+	view := *cm
+	view.masterKey = key
+	return &view, nil
+}
+
+// ForHeader returns a crypto manager bound to the key and KDF parameters recorded in a
+// license file header, so serials are verified exactly as they were produced.
+// It returns the manager itself when the header matches its active settings.
+func (cm *CryptoManager) ForHeader(h *Header) (*CryptoManager, error) {
+	keyMgr, err := cm.ForKey(h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if keyMgr.kdf == h.KDF {
+		return keyMgr, nil
+	}
+	view := *keyMgr
+	view.kdf = h.KDF
+	return &view, nil
+}
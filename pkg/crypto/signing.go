@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Asymmetric issuing mode.
+// In symmetric mode every binary that holds the master key can mint licenses.
+// In asymmetric mode licenses are additionally signed with an Ed25519 private key
+// that only the issuing tooling holds; shipped binaries embed the public key and
+// can verify licenses but never create them. The master key is still used for
+// file encryption because clients rewrite usage state on every validation.
+
+// GenerateSigningKeyPair creates a new hex-encoded Ed25519 key pair for license issuing
+func GenerateSigningKeyPair() (publicKey string, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	// Only the 32-byte seed is exported; the full private key is derived from it
+	return hex.EncodeToString(pub), hex.EncodeToString(priv.Seed()), nil
+}
+
+// ParsePrivateKey decodes a hex-encoded Ed25519 private key (32-byte seed or 64-byte key)
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key encoding: %v", err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	default:
+		return nil, fmt.Errorf("signing key must be %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(raw))
+	}
+}
+
+// ParsePublicKey decodes a hex-encoded Ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %v", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// NewCryptoManagerWithSigningKey creates a crypto manager for issuing tooling.
+// It can both sign and verify licenses.
+func NewCryptoManagerWithSigningKey(masterKey, privateKey string) (*CryptoManager, error) {
+	cm, err := NewCryptoManagerWithKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	cm.SetSigningKey(priv)
+	return cm, nil
+}
+
+// NewCryptoManagerWithPublicKey creates a crypto manager for shipped binaries.
+// It can verify signed licenses but cannot issue new ones.
+func NewCryptoManagerWithPublicKey(masterKey, publicKey string) (*CryptoManager, error) {
+	cm, err := NewCryptoManagerWithKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	cm.SetVerifyingKey(pub)
+	return cm, nil
+}
+
+// SetSigningKey switches the manager to asymmetric mode with signing capability
+func (cm *CryptoManager) SetSigningKey(key ed25519.PrivateKey) {
+	cm.signingKey = key
+	cm.verifyingKey = key.Public().(ed25519.PublicKey)
+}
+
+// SetVerifyingKey switches the manager to asymmetric mode with verification only
+func (cm *CryptoManager) SetVerifyingKey(key ed25519.PublicKey) {
+	cm.signingKey = nil
+	cm.verifyingKey = key
+}
+
+// IsAsymmetric reports whether licenses must carry a valid Ed25519 signature
+func (cm *CryptoManager) IsAsymmetric() bool {
+	return cm.verifyingKey != nil
+}
+
+// CanSign reports whether the manager holds the private issuing key
+func (cm *CryptoManager) CanSign() bool {
+	return cm.signingKey != nil
+}
+
+// Sign signs data with the private issuing key and returns a hex-encoded signature
+func (cm *CryptoManager) Sign(data []byte) (string, error) {
+	if !cm.CanSign() {
+		return "", fmt.Errorf("no signing key configured")
+	}
+	return hex.EncodeToString(ed25519.Sign(cm.signingKey, data)), nil
+}
+
+// Verify checks a hex-encoded signature against data using the public key
+func (cm *CryptoManager) Verify(data []byte, signature string) error {
+	if !cm.IsAsymmetric() {
+		return fmt.Errorf("no public key configured")
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	if !ed25519.Verify(cm.verifyingKey, data, sig) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package hardware
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Environment describes whether the process runs in a container or virtual machine,
+// where machine IDs and MAC addresses are often ephemeral or cloned
+type Environment struct {
+	// Container runtime, such as "docker", "podman", "kubernetes" or "lxc"; empty on a host
+	Container string
+	// Hypervisor, such as "kvm", "vmware", "virtualbox", "hyper-v" or "xen"; empty on bare metal
+	Hypervisor string
+	// Evidence lists what the detection was based on
+	Evidence []string
+}
+
+// IsContainer reports whether a container runtime was detected
+func (e Environment) IsContainer() bool {
+	return e.Container != ""
+}
+
+// IsVirtual reports whether a hypervisor was detected
+func (e Environment) IsVirtual() bool {
+	return e.Hypervisor != ""
+}
+
+// String returns a short description for display
+func (e Environment) String() string {
+	switch {
+	case e.IsContainer() && e.IsVirtual():
+		return e.Container + " container on " + e.Hypervisor + " virtual machine"
+	case e.IsContainer():
+		return e.Container + " container"
+	case e.IsVirtual():
+		return e.Hypervisor + " virtual machine"
+	default:
+		return "bare metal"
+	}
+}
+
+// DetectEnvironment inspects the running system. Detection is only implemented on Linux;
+// other platforms report bare metal.
+func DetectEnvironment() Environment {
+	if runtime.GOOS != "linux" {
+		return Environment{}
+	}
+	return (&EnvironmentDetector{}).Detect()
+}
+
+// EnvironmentDetector detects containers and virtual machines from Linux procfs and sysfs
+type EnvironmentDetector struct {
+	// Root is prepended to every path, "/" when empty. Tests point it at a fake tree.
+	Root string
+}
+
+// containerMarkers map strings in /proc/1/cgroup to container runtimes, most specific first
+var containerMarkers = []struct{ marker, runtime string }{
+	{"kubepods", "kubernetes"},
+	{"libpod", "podman"},
+	{"docker", "docker"},
+	{"containerd", "containerd"},
+	{"lxc", "lxc"},
+}
+
+// hypervisorVendors map DMI vendor and product strings to hypervisors
+var hypervisorVendors = []struct{ marker, hypervisor string }{
+	{"kvm", "kvm"},
+	{"qemu", "qemu"},
+	{"vmware", "vmware"},
+	{"virtualbox", "virtualbox"},
+	{"innotek", "virtualbox"},
+	{"virtual machine", "hyper-v"},
+	{"xen", "xen"},
+	{"parallels", "parallels"},
+	{"amazon ec2", "aws"},
+	{"google compute engine", "gce"},
+}
+
+// Detect returns the detected environment
+func (d *EnvironmentDetector) Detect() Environment {
+	var env Environment
+	d.detectContainer(&env)
+	d.detectHypervisor(&env)
+	return env
+}
+
+// detectContainer looks for runtime marker files and container cgroups
+func (d *EnvironmentDetector) detectContainer(env *Environment) {
+	markerFiles := []struct{ path, runtime string }{
+		{"/.dockerenv", "docker"},
+		{"/run/.containerenv", "podman"},
+		{"/var/run/secrets/kubernetes.io/serviceaccount", "kubernetes"},
+	}
+	for _, m := range markerFiles {
+		if _, err := os.Stat(d.path(m.path)); err == nil {
+			env.Evidence = append(env.Evidence, m.path+" exists")
+			if env.Container == "" {
+				env.Container = m.runtime
+			}
+		}
+	}
+
+	// systemd records the container manager it was started by
+	if manager := d.readLower("/run/systemd/container"); manager != "" {
+		env.Evidence = append(env.Evidence, "/run/systemd/container is "+manager)
+		if env.Container == "" {
+			env.Container = manager
+		}
+	}
+
+	if cgroup := d.readLower("/proc/1/cgroup"); cgroup != "" {
+		for _, m := range containerMarkers {
+			if strings.Contains(cgroup, m.marker) {
+				env.Evidence = append(env.Evidence, "/proc/1/cgroup mentions "+m.marker)
+				if env.Container == "" {
+					env.Container = m.runtime
+				}
+				break
+			}
+		}
+	}
+}
+
+// detectHypervisor looks at DMI vendor strings and the CPU hypervisor flag
+func (d *EnvironmentDetector) detectHypervisor(env *Environment) {
+	for _, name := range []string{"sys_vendor", "product_name", "bios_vendor"} {
+		value := d.readLower("/sys/class/dmi/id/" + name)
+		if value == "" {
+			continue
+		}
+		for _, v := range hypervisorVendors {
+			if strings.Contains(value, v.marker) {
+				env.Evidence = append(env.Evidence, "DMI "+name+" is "+value)
+				if env.Hypervisor == "" {
+					env.Hypervisor = v.hypervisor
+				}
+				break
+			}
+		}
+	}
+
+	if hypervisorType := d.readLower("/sys/hypervisor/type"); hypervisorType != "" {
+		env.Evidence = append(env.Evidence, "/sys/hypervisor/type is "+hypervisorType)
+		if env.Hypervisor == "" {
+			env.Hypervisor = hypervisorType
+		}
+	}
+
+	if env.Hypervisor == "" {
+		cpuInfo := d.readLower("/proc/cpuinfo")
+		for line := range strings.SplitSeq(cpuInfo, "\n") {
+			if strings.HasPrefix(line, "flags") && strings.Contains(line, " hypervisor") {
+				env.Evidence = append(env.Evidence, "/proc/cpuinfo has the hypervisor flag")
+				env.Hypervisor = "unknown"
+				break
+			}
+		}
+	}
+}
+
+// readLower returns the trimmed, lower-cased contents of a file below Root, or ""
+func (d *EnvironmentDetector) readLower(name string) string {
+	data, err := os.ReadFile(d.path(name))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(string(data)))
+}
+
+// path maps an absolute system path below Root
+func (d *EnvironmentDetector) path(name string) string {
+	if d.Root == "" {
+		return name
+	}
+	return filepath.Join(d.Root, name)
+}
@@ -0,0 +1,88 @@
+package hardware
+
+import (
+	"testing"
+)
+
+// TestDetectBareMetal tests that a tree without container or hypervisor markers is bare metal
+func TestDetectBareMetal(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "proc/1/cgroup", "0::/init.scope\n")
+	writeFile(t, root, "sys/class/dmi/id/sys_vendor", "Dell Inc.\n")
+	writeFile(t, root, "proc/cpuinfo", "processor\t: 0\nflags\t\t: fpu vme de pse\n")
+
+	env := (&EnvironmentDetector{Root: root}).Detect()
+	if env.IsContainer() || env.IsVirtual() {
+		t.Errorf("Expected bare metal, got %s (%v)", env, env.Evidence)
+	}
+	if env.String() != "bare metal" {
+		t.Errorf("Expected 'bare metal', got %q", env.String())
+	}
+}
+
+// TestDetectContainer tests container detection from marker files and cgroups
+func TestDetectContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{"dockerenv", map[string]string{".dockerenv": ""}, "docker"},
+		{"podman", map[string]string{"run/.containerenv": ""}, "podman"},
+		{"systemd", map[string]string{"run/systemd/container": "systemd-nspawn\n"}, "systemd-nspawn"},
+		{"kubernetes cgroup", map[string]string{"proc/1/cgroup": "0::/kubepods/besteffort/pod1234/abcd\n"}, "kubernetes"},
+		{"docker cgroup", map[string]string{"proc/1/cgroup": "12:memory:/docker/0123abcd\n"}, "docker"},
+		{"lxc cgroup", map[string]string{"proc/1/cgroup": "0::/lxc.payload.web\n"}, "lxc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, root, name, content)
+			}
+			env := (&EnvironmentDetector{Root: root}).Detect()
+			if env.Container != tt.expected {
+				t.Errorf("Expected container %q, got %q", tt.expected, env.Container)
+			}
+			if len(env.Evidence) == 0 {
+				t.Errorf("Expected detection evidence")
+			}
+		})
+	}
+}
+
+// TestDetectHypervisor tests virtual machine detection from DMI strings and CPU flags
+func TestDetectHypervisor(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{"vmware", map[string]string{"sys/class/dmi/id/sys_vendor": "VMware, Inc.\n"}, "vmware"},
+		{"virtualbox", map[string]string{"sys/class/dmi/id/sys_vendor": "innotek GmbH\n"}, "virtualbox"},
+		{"hyper-v", map[string]string{
+			"sys/class/dmi/id/sys_vendor":   "Microsoft Corporation\n",
+			"sys/class/dmi/id/product_name": "Virtual Machine\n",
+		}, "hyper-v"},
+		{"qemu", map[string]string{"sys/class/dmi/id/product_name": "Standard PC (Q35 + ICH9, 2009)\n", "sys/class/dmi/id/sys_vendor": "QEMU\n"}, "qemu"},
+		{"xen", map[string]string{"sys/hypervisor/type": "xen\n"}, "xen"},
+		{"cpu flag", map[string]string{"proc/cpuinfo": "processor\t: 0\nflags\t\t: fpu vme hypervisor lahf_lm\n"}, "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, root, name, content)
+			}
+			env := (&EnvironmentDetector{Root: root}).Detect()
+			if env.Hypervisor != tt.expected {
+				t.Errorf("Expected hypervisor %q, got %q", tt.expected, env.Hypervisor)
+			}
+			if env.IsContainer() {
+				t.Errorf("Expected no container, got %q", env.Container)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package hardware
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Probe describes one hardware identifier a fingerprinter looked for
+type Probe struct {
+	// Name of the component the probe contributes, see Component
+	Name string
+	// Source the value was read from: a file, registry key or command
+	Source string
+	Found  bool
+	Value  string
+	// Fingerprint of the component as recorded in licenses, empty when not found
+	Fingerprint string
+}
+
+// Explainer is implemented by fingerprinters that can report each probe they ran
+type Explainer interface {
+	Probes() []Probe
+}
+
+// newProbe returns a probe that has not found a value yet
+func newProbe(name, source string) Probe {
+	return Probe{Name: name, Source: source}
+}
+
+// setValue records a found value; empty values leave the probe not found
+func (p *Probe) setValue(value string) {
+	if value == "" {
+		return
+	}
+	p.Found = true
+	p.Value = value
+	p.Fingerprint = Component{Name: p.Name, Value: value}.Fingerprint()
+}
+
+// Redacted returns a copy of the probe with all but the last four characters of the
+// value masked, for sharing in support tickets. The fingerprint is kept for comparison.
+func (p Probe) Redacted() Probe {
+	if len(p.Value) <= 4 {
+		p.Value = strings.Repeat("*", len(p.Value))
+	} else {
+		p.Value = strings.Repeat("*", len(p.Value)-4) + p.Value[len(p.Value)-4:]
+	}
+	return p
+}
+
+// FoundComponents returns the components of the probes that found a value, in probe order
+func FoundComponents(probes []Probe) []Component {
+	var components []Component
+	for _, probe := range probes {
+		if probe.Found {
+			components = append(components, Component{Name: probe.Name, Value: probe.Value})
+		}
+	}
+	return components
+}
+
+// Explain lists the probes behind a fingerprinter's PC ID. Fingerprinters that do not
+// implement Explainer are reported as one found probe per component.
+func Explain(f Fingerprinter) ([]Probe, error) {
+	if explainer, ok := f.(Explainer); ok {
+		return explainer.Probes(), nil
+	}
+
+	components, err := f.Components()
+	if err != nil {
+		return nil, err
+	}
+	probes := make([]Probe, len(components))
+	for i, c := range components {
+		probes[i] = newProbe(c.Name, fmt.Sprintf("%T", f))
+		probes[i].setValue(c.Value)
+	}
+	return probes, nil
+}
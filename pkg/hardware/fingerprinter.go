@@ -0,0 +1,122 @@
+package hardware
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Fingerprinter identifies the machine a license is bound to.
+// The PC ID is the hash of the returned components, see HashComponents.
+type Fingerprinter interface {
+	Components() ([]Component, error)
+}
+
+// StaticFingerprinter returns a fixed ID, for tests and CI where hardware is not stable
+type StaticFingerprinter struct {
+	ID string
+	// Name of the component, "static" when empty
+	Name string
+}
+
+// Components returns the configured ID
+func (f *StaticFingerprinter) Components() ([]Component, error) {
+	if f.ID == "" {
+		return nil, fmt.Errorf("static fingerprinter has no ID")
+	}
+	return []Component{{Name: componentName(f.Name, "static"), Value: f.ID}}, nil
+}
+
+// FileFingerprinter reads the machine ID from a file, such as a host ID mounted into a container
+type FileFingerprinter struct {
+	Path string
+	// Name of the component, "file" when empty
+	Name string
+}
+
+// Components returns the trimmed contents of the file
+func (f *FileFingerprinter) Components() ([]Component, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ID file: %v", err)
+	}
+	id := strings.TrimSpace(string(data))
+	if id == "" {
+		return nil, fmt.Errorf("ID file %s is empty", f.Path)
+	}
+	return []Component{{Name: componentName(f.Name, "file"), Value: id}}, nil
+}
+
+// containerIDPattern matches the 64 hex character IDs used by Docker, containerd and Podman
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// mountContainerIDPattern matches container IDs in the paths of files bind-mounted by the runtime
+var mountContainerIDPattern = regexp.MustCompile(`containers/([0-9a-f]{64})`)
+
+// ContainerFingerprinter binds to the ID of the container the process runs in.
+// The ID changes when the container is recreated, so it suits long-lived containers only.
+type ContainerFingerprinter struct {
+	// CgroupFile defaults to /proc/self/cgroup, MountinfoFile to /proc/self/mountinfo
+	CgroupFile    string
+	MountinfoFile string
+}
+
+// Components returns the container ID found in the cgroup or mount tables
+func (f *ContainerFingerprinter) Components() ([]Component, error) {
+	cgroupFile := f.CgroupFile
+	if cgroupFile == "" {
+		cgroupFile = "/proc/self/cgroup"
+	}
+	mountinfoFile := f.MountinfoFile
+	if mountinfoFile == "" {
+		mountinfoFile = "/proc/self/mountinfo"
+	}
+
+	// cgroup v1 paths contain the ID directly
+	if data, err := os.ReadFile(cgroupFile); err == nil {
+		if id := containerIDPattern.FindString(string(data)); id != "" {
+			return []Component{{Name: "container", Value: id}}, nil
+		}
+	}
+	// with cgroup v2 the ID only shows up in the runtime's bind mounts (hostname, resolv.conf)
+	if data, err := os.ReadFile(mountinfoFile); err == nil {
+		if match := mountContainerIDPattern.FindStringSubmatch(string(data)); match != nil {
+			return []Component{{Name: "container", Value: match[1]}}, nil
+		}
+	}
+	return nil, fmt.Errorf("could not determine container ID - not running in a recognized container")
+}
+
+// CompositeFingerprinter combines the components of several fingerprinters.
+// Like the hardware probes, sources that fail are skipped; it only fails when all of them do.
+// Component names should be unique across sources.
+type CompositeFingerprinter struct {
+	Sources []Fingerprinter
+}
+
+// Components returns the components of every source that succeeded, in source order
+func (f *CompositeFingerprinter) Components() ([]Component, error) {
+	var components []Component
+	var errs []string
+	for _, source := range f.Sources {
+		sourceComponents, err := source.Components()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		components = append(components, sourceComponents...)
+	}
+	if len(components) == 0 {
+		return nil, fmt.Errorf("no fingerprint source succeeded: %s", strings.Join(errs, "; "))
+	}
+	return components, nil
+}
+
+// componentName returns name, or fallback when it is empty
+func componentName(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
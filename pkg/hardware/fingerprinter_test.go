@@ -0,0 +1,106 @@
+package hardware
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testContainerID = "3f4e1c2b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+
+// writeFile writes a test fixture into dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// TestFileFingerprinter tests reading a machine ID from a file
+func TestFileFingerprinter(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "host-id", "  host-1234\n")
+
+	components, err := (&FileFingerprinter{Path: path}).Components()
+	if err != nil {
+		t.Fatalf("Failed to read ID file: %v", err)
+	}
+	if len(components) != 1 || components[0] != (Component{Name: "file", Value: "host-1234"}) {
+		t.Errorf("Unexpected components: %v", components)
+	}
+
+	empty := writeFile(t, dir, "empty", "\n")
+	if _, err := (&FileFingerprinter{Path: empty}).Components(); err == nil {
+		t.Errorf("Expected empty ID file to be rejected")
+	}
+	if _, err := (&FileFingerprinter{Path: filepath.Join(dir, "missing")}).Components(); err == nil {
+		t.Errorf("Expected missing ID file to be rejected")
+	}
+}
+
+// TestContainerFingerprinter tests container ID detection for cgroup v1 and v2
+func TestContainerFingerprinter(t *testing.T) {
+	dir := t.TempDir()
+
+	cgroupV1 := writeFile(t, dir, "v1/cgroup", "12:pids:/docker/"+testContainerID+"\n")
+	f := &ContainerFingerprinter{CgroupFile: cgroupV1, MountinfoFile: filepath.Join(dir, "none")}
+	components, err := f.Components()
+	if err != nil || components[0].Value != testContainerID {
+		t.Errorf("Expected container ID from cgroup, got %v (err %v)", components, err)
+	}
+
+	cgroupV2 := writeFile(t, dir, "v2/cgroup", "0::/\n")
+	mountinfo := writeFile(t, dir, "v2/mountinfo",
+		"612 590 254:1 /docker/containers/"+testContainerID+"/hostname /etc/hostname rw,relatime - ext4 /dev/vda1 rw\n")
+	f = &ContainerFingerprinter{CgroupFile: cgroupV2, MountinfoFile: mountinfo}
+	components, err = f.Components()
+	if err != nil || components[0].Value != testContainerID {
+		t.Errorf("Expected container ID from mountinfo, got %v (err %v)", components, err)
+	}
+
+	hostMountinfo := writeFile(t, dir, "host/mountinfo", "22 1 254:1 / / rw,relatime - ext4 /dev/vda1 rw\n")
+	f = &ContainerFingerprinter{CgroupFile: cgroupV2, MountinfoFile: hostMountinfo}
+	if _, err := f.Components(); err == nil {
+		t.Errorf("Expected no container ID outside a container")
+	}
+}
+
+// TestCompositeFingerprinter tests combining sources and skipping failed ones
+func TestCompositeFingerprinter(t *testing.T) {
+	dir := t.TempDir()
+	f := &CompositeFingerprinter{Sources: []Fingerprinter{
+		&FileFingerprinter{Path: filepath.Join(dir, "missing")},
+		&StaticFingerprinter{ID: "a", Name: "first"},
+		&StaticFingerprinter{ID: "b", Name: "second"},
+	}}
+
+	components, err := f.Components()
+	if err != nil {
+		t.Fatalf("Failed to collect components: %v", err)
+	}
+	if len(components) != 2 || components[0].Name != "first" || components[1].Name != "second" {
+		t.Errorf("Expected components in source order, got %v", components)
+	}
+
+	f = &CompositeFingerprinter{Sources: []Fingerprinter{&StaticFingerprinter{}}}
+	if _, err := f.Components(); err == nil || !strings.Contains(err.Error(), "no ID") {
+		t.Errorf("Expected error when every source fails, got %v", err)
+	}
+}
+
+// TestExplainWithoutProbes tests explaining a fingerprinter that only reports components
+func TestExplainWithoutProbes(t *testing.T) {
+	probes, err := Explain(&StaticFingerprinter{ID: "ci-runner"})
+	if err != nil {
+		t.Fatalf("Failed to explain fingerprinter: %v", err)
+	}
+	if len(probes) != 1 || !probes[0].Found || probes[0].Value != "ci-runner" || probes[0].Source != "*hardware.StaticFingerprinter" {
+		t.Errorf("Unexpected probes %+v", probes)
+	}
+}
@@ -0,0 +1,106 @@
+package hardware
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LinuxCollector reads Linux hardware identifiers directly from procfs and sysfs
+// instead of running external commands
+type LinuxCollector struct {
+	// Root is prepended to every path, "/" when empty. Tests point it at a fake tree.
+	Root string
+}
+
+// Components returns the machine ID and the physical MAC addresses, in that order.
+// Unreadable sources are skipped. DMI identifiers such as the product UUID and board serial
+// are not used: the kernel makes them readable by root only, so root and other users would
+// get different PC IDs.
+func (c *LinuxCollector) Components() ([]Component, error) {
+	return FoundComponents(c.Probes()), nil
+}
+
+// Probes reports each Linux identifier and where it was looked for
+func (c *LinuxCollector) Probes() []Probe {
+	machine := newProbe("machine", "/etc/machine-id")
+	if id := c.readTrimmed("/etc/machine-id"); id != "" {
+		machine.setValue(id)
+	} else if id := c.readTrimmed("/var/lib/dbus/machine-id"); id != "" {
+		machine.Source = "/var/lib/dbus/machine-id"
+		machine.setValue(id)
+	}
+
+	mac := newProbe("mac", "/sys/class/net/*/address (physical interfaces)")
+	mac.setValue(strings.Join(c.physicalMACs(), ","))
+
+	return []Probe{machine, mac}
+}
+
+// LegacyPCID returns the PC ID that releases before the native collector generated on
+// Linux, from /etc/machine-id and the first processor line of /proc/cpuinfo.
+// It is empty when neither source is available.
+func (c *LinuxCollector) LegacyPCID() string {
+	var components []Component
+	if machineID := c.readTrimmed("/etc/machine-id"); machineID != "" {
+		components = append(components, Component{Name: "machine", Value: machineID})
+	}
+	if cpuInfo, err := os.ReadFile(c.path("/proc/cpuinfo")); err == nil {
+		for line := range strings.SplitSeq(string(cpuInfo), "\n") {
+			if strings.Contains(line, "processor") && strings.Contains(line, "0") {
+				components = append(components, Component{Name: "cpu", Value: strings.TrimSpace(line)})
+				break
+			}
+		}
+	}
+	if len(components) == 0 {
+		return ""
+	}
+	return HashComponents(components)
+}
+
+// physicalMACs returns the sorted MAC addresses of interfaces backed by a device.
+// Loopback, bridges, veth pairs, tunnels and other virtual interfaces have no device link.
+func (c *LinuxCollector) physicalMACs() []string {
+	netDir := c.path("/sys/class/net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return nil
+	}
+
+	var macs []string
+	for _, entry := range entries {
+		iface := entry.Name()
+		if iface == "lo" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(netDir, iface, "device")); err != nil {
+			continue
+		}
+		mac := strings.ToLower(c.readTrimmed(filepath.Join("/sys/class/net", iface, "address")))
+		if mac == "" || mac == "00:00:00:00:00:00" {
+			continue
+		}
+		macs = append(macs, mac)
+	}
+	slices.Sort(macs)
+	return slices.Compact(macs)
+}
+
+// readTrimmed returns the trimmed contents of a file below Root, or "" when it cannot be read
+func (c *LinuxCollector) readTrimmed(name string) string {
+	data, err := os.ReadFile(c.path(name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// path maps an absolute system path below Root
+func (c *LinuxCollector) path(name string) string {
+	if c.Root == "" {
+		return name
+	}
+	return filepath.Join(c.Root, name)
+}
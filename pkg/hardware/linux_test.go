@@ -0,0 +1,151 @@
+package hardware
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeInterface adds a network interface to a fake sysfs tree
+func fakeInterface(t *testing.T, root, name, mac string, physical bool) {
+	t.Helper()
+	writeFile(t, root, filepath.Join("sys/class/net", name, "address"), mac+"\n")
+	if physical {
+		if err := os.MkdirAll(filepath.Join(root, "sys/class/net", name, "device"), 0755); err != nil {
+			t.Fatalf("Failed to create device link: %v", err)
+		}
+	}
+}
+
+// TestLinuxCollector tests reading identifiers from a fake procfs and sysfs tree
+func TestLinuxCollector(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "etc/machine-id", "4c4c4544003a\n")
+	writeFile(t, root, "sys/class/dmi/id/product_uuid", "4C4C4544-0042-3510-8036-B4C04F4B3732\n")
+	writeFile(t, root, "sys/class/dmi/id/board_serial", "To be filled by O.E.M.\n")
+	fakeInterface(t, root, "lo", "00:00:00:00:00:00", false)
+	fakeInterface(t, root, "wlan0", "AA:BB:CC:00:00:02", true)
+	fakeInterface(t, root, "eth0", "aa:bb:cc:00:00:01", true)
+	fakeInterface(t, root, "docker0", "02:42:ac:11:00:01", false)
+	fakeInterface(t, root, "veth12ab", "7e:11:22:33:44:55", false)
+
+	components, err := (&LinuxCollector{Root: root}).Components()
+	if err != nil {
+		t.Fatalf("Failed to collect components: %v", err)
+	}
+
+	expected := []Component{
+		{Name: "machine", Value: "4c4c4544003a"},
+		{Name: "mac", Value: "aa:bb:cc:00:00:01,aa:bb:cc:00:00:02"},
+	}
+	if len(components) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, components)
+	}
+	for i := range expected {
+		if components[i] != expected[i] {
+			t.Errorf("Component %d: expected %v, got %v", i, expected[i], components[i])
+		}
+	}
+}
+
+// TestLinuxCollectorIgnoresDMI tests that DMI identifiers do not contribute to the PC ID,
+// whether or not the current user can read them, so root and other users get the same ID
+func TestLinuxCollectorIgnoresDMI(t *testing.T) {
+	withoutDMI := t.TempDir()
+	writeFile(t, withoutDMI, "etc/machine-id", "id\n")
+
+	root := t.TempDir()
+	writeFile(t, root, "etc/machine-id", "id\n")
+	writeFile(t, root, "sys/class/dmi/id/product_uuid", "4C4C4544-0042-3510-8036-B4C04F4B3732\n")
+	serial := writeFile(t, root, "sys/class/dmi/id/board_serial", "BSN12345\n")
+	if err := os.Chmod(serial, 0400); err != nil {
+		t.Fatalf("Failed to restrict %s: %v", serial, err)
+	}
+
+	components, _ := (&LinuxCollector{Root: root}).Components()
+	expected, _ := (&LinuxCollector{Root: withoutDMI}).Components()
+	if HashComponents(components) != HashComponents(expected) {
+		t.Errorf("Expected DMI identifiers to be ignored, got %v", components)
+	}
+}
+
+// TestLinuxCollectorDBusMachineID tests the D-Bus machine ID fallback
+func TestLinuxCollectorDBusMachineID(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "var/lib/dbus/machine-id", "dbus-id\n")
+
+	components, err := (&LinuxCollector{Root: root}).Components()
+	if err != nil {
+		t.Fatalf("Failed to collect components: %v", err)
+	}
+	if len(components) != 1 || components[0] != (Component{Name: "machine", Value: "dbus-id"}) {
+		t.Errorf("Expected machine ID from D-Bus, got %v", components)
+	}
+}
+
+// TestLinuxCollectorDeterministic tests that the PC ID does not depend on directory order
+func TestLinuxCollectorDeterministic(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+	for _, root := range []string{first, second} {
+		writeFile(t, root, "etc/machine-id", "id\n")
+	}
+	fakeInterface(t, first, "eth0", "aa:00:00:00:00:01", true)
+	fakeInterface(t, first, "eth1", "aa:00:00:00:00:02", true)
+	fakeInterface(t, second, "eth1", "aa:00:00:00:00:01", true)
+	fakeInterface(t, second, "eth0", "aa:00:00:00:00:02", true)
+
+	a, _ := (&LinuxCollector{Root: first}).Components()
+	b, _ := (&LinuxCollector{Root: second}).Components()
+	if HashComponents(a) != HashComponents(b) {
+		t.Errorf("Expected the same PC ID for the same set of MAC addresses")
+	}
+}
+
+// TestLinuxCollectorLegacyPCID tests that the PC ID of earlier releases can still be computed
+func TestLinuxCollectorLegacyPCID(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "etc/machine-id", "abc123\n")
+	writeFile(t, root, "proc/cpuinfo", "processor\t: 0\nvendor_id\t: GenuineIntel\n\nprocessor\t: 1\n")
+
+	expected := HashComponents([]Component{
+		{Name: "machine", Value: "abc123"},
+		{Name: "cpu", Value: "processor\t: 0"},
+	})
+	if legacy := (&LinuxCollector{Root: root}).LegacyPCID(); legacy != expected {
+		t.Errorf("Expected legacy PC ID %s, got %s", expected, legacy)
+	}
+
+	if legacy := (&LinuxCollector{Root: t.TempDir()}).LegacyPCID(); legacy != "" {
+		t.Errorf("Expected no legacy PC ID without sources, got %s", legacy)
+	}
+}
+
+// TestLinuxCollectorProbes tests that probes report sources, missing components and fingerprints
+func TestLinuxCollectorProbes(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "var/lib/dbus/machine-id", "0123456789abcdef\n")
+
+	probes := (&LinuxCollector{Root: root}).Probes()
+	if len(probes) != 2 {
+		t.Fatalf("Expected 2 probes, got %d", len(probes))
+	}
+
+	machine := probes[0]
+	if !machine.Found || machine.Source != "/var/lib/dbus/machine-id" {
+		t.Errorf("Expected machine ID found in the D-Bus file, got %+v", machine)
+	}
+	if machine.Fingerprint != (Component{Name: "machine", Value: "0123456789abcdef"}).Fingerprint() {
+		t.Errorf("Expected probe fingerprint to match the component fingerprint")
+	}
+	for _, probe := range probes[1:] {
+		if probe.Found || probe.Fingerprint != "" {
+			t.Errorf("Expected %s to be missing, got %+v", probe.Name, probe)
+		}
+	}
+
+	redacted := machine.Redacted()
+	if redacted.Value != "************cdef" || redacted.Fingerprint != machine.Fingerprint {
+		t.Errorf("Unexpected redacted probe %+v", redacted)
+	}
+}
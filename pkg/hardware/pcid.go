@@ -18,88 +18,148 @@ func NewPCIDGenerator() *PCIDGenerator {
 	return &PCIDGenerator{}
 }
 
+// Component is a single hardware identifier that feeds into the PC ID
+type Component struct {
+	// Name identifies the kind of component, such as "machine", "cpu" or "mac"
+	Name  string
+	Value string
+}
+
+// String returns the name:value form that is hashed into the PC ID
+func (c Component) String() string {
+	return c.Name + ":" + c.Value
+}
+
 // Generate creates a unique PC ID based on hardware characteristics
 // This uses the exact same logic as the original GeneratePCId function
 func (p *PCIDGenerator) Generate() (string, error) {
-	var components []string
+	components, err := p.Components()
+	if err != nil {
+		return "", err
+	}
+	return HashComponents(components), nil
+}
+
+// HashComponents combines components into a PC ID
+func HashComponents(components []Component) string {
+	parts := make([]string, len(components))
+	for i, c := range components {
+		parts[i] = c.String()
+	}
+	combined := strings.Join(parts, "|")
+	hash := sha256.Sum256([]byte(combined))
+	return hex.EncodeToString(hash[:16])
+}
 
+// Fingerprint returns a short hash of the component, so a license can record the
+// hardware it was issued for without storing raw serial numbers
+func (c Component) Fingerprint() string {
+	hash := sha256.Sum256([]byte(c.String()))
+	return hex.EncodeToString(hash[:8])
+}
+
+// Fingerprints returns the fingerprint of each component keyed by component name
+func Fingerprints(components []Component) map[string]string {
+	fingerprints := make(map[string]string, len(components))
+	for _, c := range components {
+		fingerprints[c.Name] = c.Fingerprint()
+	}
+	return fingerprints
+}
+
+// Components collects the hardware identifiers of this machine in a fixed order
+func (p *PCIDGenerator) Components() ([]Component, error) {
+	components := FoundComponents(p.Probes())
+	if len(components) == 0 {
+		return nil, fmt.Errorf("could not generate PC ID - no hardware identifiers found")
+	}
+	return components, nil
+}
+
+// Probes reports every hardware identifier looked for on this platform, including
+// the ones that were not found
+func (p *PCIDGenerator) Probes() []Probe {
 	switch runtime.GOOS {
 	case "windows":
-		if cpu := p.runCmd("wmic", "cpu", "get", "ProcessorId", "/format:list"); cpu != "" {
-			if val := p.extractValue(cpu, "ProcessorId"); val != "" {
-				components = append(components, "cpu:"+val)
-			}
+		cpu := newProbe("cpu", "wmic cpu get ProcessorId")
+		if out := p.runCmd("wmic", "cpu", "get", "ProcessorId", "/format:list"); out != "" {
+			cpu.setValue(p.extractValue(out, "ProcessorId"))
 		}
-		if mb := p.runCmd("wmic", "baseboard", "get", "SerialNumber", "/format:list"); mb != "" {
-			if val := p.extractValue(mb, "SerialNumber"); val != "" {
-				components = append(components, "mb:"+val)
-			}
+		mb := newProbe("mb", "wmic baseboard get SerialNumber")
+		if out := p.runCmd("wmic", "baseboard", "get", "SerialNumber", "/format:list"); out != "" {
+			mb.setValue(p.extractValue(out, "SerialNumber"))
 		}
-		if guid := p.runCmd("reg", "query", "HKLM\\SOFTWARE\\Microsoft\\Cryptography", "/v", "MachineGuid"); guid != "" {
-			lines := strings.SplitSeq(guid, "\n")
-			for line := range lines {
+		guid := newProbe("guid", "HKLM\\SOFTWARE\\Microsoft\\Cryptography\\MachineGuid")
+		if out := p.runCmd("reg", "query", "HKLM\\SOFTWARE\\Microsoft\\Cryptography", "/v", "MachineGuid"); out != "" {
+			for line := range strings.SplitSeq(out, "\n") {
 				if strings.Contains(line, "MachineGuid") {
 					parts := strings.Fields(line)
 					if len(parts) >= 3 {
-						components = append(components, "guid:"+parts[2])
+						guid.setValue(parts[2])
 						break
 					}
 				}
 			}
 		}
+		return []Probe{cpu, mb, guid}
 	case "linux":
-		if machineId := p.runCmd("cat", "/etc/machine-id"); machineId != "" {
-			components = append(components, "machine:"+strings.TrimSpace(machineId))
-		}
-		if cpuInfo := p.runCmd("cat", "/proc/cpuinfo"); cpuInfo != "" {
-			lines := strings.SplitSeq(cpuInfo, "\n")
-			for line := range lines {
-				if strings.Contains(line, "processor") && strings.Contains(line, "0") {
-					components = append(components, "cpu:"+strings.TrimSpace(line))
-					break
-				}
-			}
-		}
-		if mac := p.runCmd("cat", "/sys/class/net/*/address"); mac != "" {
-			macs := strings.Split(strings.TrimSpace(mac), "\n")
-			if len(macs) > 0 && macs[0] != "00:00:00:00:00:00" {
-				components = append(components, "mac:"+macs[0])
-			}
-		}
+		return (&LinuxCollector{}).Probes()
 	case "darwin":
-		if uuid := p.runCmd("system_profiler", "SPHardwareDataType"); uuid != "" {
-			lines := strings.SplitSeq(uuid, "\n")
-			for line := range lines {
-				if strings.Contains(line, "Hardware UUID:") {
+		uuid := newProbe("uuid", "system_profiler SPHardwareDataType (Hardware UUID)")
+		serial := newProbe("serial", "system_profiler SPHardwareDataType (Serial Number)")
+		if out := p.runCmd("system_profiler", "SPHardwareDataType"); out != "" {
+			for line := range strings.SplitSeq(out, "\n") {
+				if strings.Contains(line, "Hardware UUID:") && !uuid.Found {
 					parts := strings.Split(line, ":")
 					if len(parts) >= 2 {
-						components = append(components, "uuid:"+strings.TrimSpace(strings.Join(parts[1:], ":")))
-						break
+						uuid.setValue(strings.TrimSpace(strings.Join(parts[1:], ":")))
 					}
 				}
-			}
-		}
-		if serial := p.runCmd("system_profiler", "SPHardwareDataType"); serial != "" {
-			lines := strings.SplitSeq(serial, "\n")
-			for line := range lines {
-				if strings.Contains(line, "Serial Number") {
+				if strings.Contains(line, "Serial Number") && !serial.Found {
 					parts := strings.Split(line, ":")
 					if len(parts) >= 2 {
-						components = append(components, "serial:"+strings.TrimSpace(parts[1]))
-						break
+						serial.setValue(strings.TrimSpace(parts[1]))
 					}
 				}
 			}
 		}
+		return []Probe{uuid, serial}
+	default:
+		return nil
 	}
+}
 
-	if len(components) == 0 {
-		return "", fmt.Errorf("could not generate PC ID - no hardware identifiers found")
+// PCIDLength is the number of hex characters in a PC ID
+const PCIDLength = 32
+
+// ParsePCID checks that id has the format produced by Generate and returns it normalized,
+// so PC IDs copied from `license-manager pcid` output can be used as license targets
+func ParsePCID(id string) (string, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if len(id) != PCIDLength {
+		return "", fmt.Errorf("invalid PC ID %q: expected %d hex characters", id, PCIDLength)
 	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", fmt.Errorf("invalid PC ID %q: expected %d hex characters", id, PCIDLength)
+	}
+	return id, nil
+}
 
-	combined := strings.Join(components, "|")
-	hash := sha256.Sum256([]byte(combined))
-	return hex.EncodeToString(hash[:16]), nil
+// LegacyIdentifier is implemented by fingerprinters whose PC ID scheme has changed.
+// Licenses bound to one of the legacy IDs keep validating on the same machine.
+type LegacyIdentifier interface {
+	LegacyPCIDs() []string
+}
+
+// LegacyPCIDs returns the PC IDs earlier releases generated on this machine
+func (p *PCIDGenerator) LegacyPCIDs() []string {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	if legacy := (&LinuxCollector{}).LegacyPCID(); legacy != "" {
+		return []string{legacy}
+	}
+	return nil
 }
 
 // runCmd executes a system command and returns its output
@@ -0,0 +1,209 @@
+package license
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AmrEsam0/license-manager/pkg/crypto"
+	"github.com/AmrEsam0/license-manager/pkg/hardware"
+)
+
+// activationRequestVersion is the version of the activation request file format
+const activationRequestVersion = 1
+
+// ActivationRequest is generated on a customer machine and carried to the issuer,
+// who answers it with a license file bound to that machine. No network is involved.
+type ActivationRequest struct {
+	Version     int       `json:"version"`
+	PCId        string    `json:"pc_id"`
+	ProductName string    `json:"product_name"`
+	Nonce       string    `json:"nonce"`
+	CreatedAt   time.Time `json:"created_at"`
+	KeyID       string    `json:"key_id"`
+	// Fingerprints of the requesting machine's hardware components, see License.Fingerprints
+	Fingerprints map[string]string `json:"fingerprints,omitempty"`
+	// MAC authenticates the fields above with a key derived from the master key
+	MAC string `json:"mac"`
+}
+
+// payload returns the canonical encoding covered by the request MAC
+func (r *ActivationRequest) payload() ([]byte, error) {
+	unsigned := *r
+	unsigned.MAC = ""
+	unsigned.CreatedAt = r.CreatedAt.UTC()
+	return json.Marshal(unsigned)
+}
+
+// ParseActivationRequest decodes an activation request file
+func ParseActivationRequest(data []byte) (*ActivationRequest, error) {
+	var req ActivationRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("failed to parse activation request: %v", err)
+	}
+	if req.Version != activationRequestVersion {
+		return nil, fmt.Errorf("unsupported activation request version %d", req.Version)
+	}
+	if req.PCId == "" || req.ProductName == "" || req.Nonce == "" {
+		return nil, fmt.Errorf("activation request is incomplete")
+	}
+	return &req, nil
+}
+
+// CreateActivationRequest creates an activation request for this machine and records
+// its nonce, so that only the license issued in answer to it can be activated
+func (m *Manager) CreateActivationRequest(productName string) (*ActivationRequest, error) {
+	if err := m.checkEnvironment(); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	req := &ActivationRequest{
+		Version:     activationRequestVersion,
+		PCId:        m.PCID,
+		ProductName: productName,
+		Nonce:       hex.EncodeToString(nonce),
+		CreatedAt:   m.now().UTC(),
+		KeyID:       m.crypto.KeyID(),
+
+		Fingerprints: m.fingerprints,
+	}
+	payload, err := req.payload()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode activation request: %v", err)
+	}
+	if req.MAC, err = m.crypto.ActivationMAC(payload); err != nil {
+		return nil, fmt.Errorf("failed to authenticate activation request: %v", err)
+	}
+
+	pendingFile, err := m.config.GetActivationFilePathForProduct(productName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activation file path: %v", err)
+	}
+	sealed, err := m.crypto.Seal([]byte(req.Nonce), productName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt activation state: %v", err)
+	}
+	if err := writeFileAtomic(pendingFile, sealed, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write activation state: %v", err)
+	}
+
+	return req, nil
+}
+
+// IssueActivation answers an activation request with the contents of a license file
+// for the requesting machine. The request's product is used when req.ProductName is empty.
+func (m *Manager) IssueActivation(ar *ActivationRequest, req CreateLicenseRequest) ([]byte, *License, error) {
+	keyCrypto, err := m.crypto.ForKey(ar.KeyID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("activation request was created with an unknown master key: %v", err)
+	}
+	payload, err := ar.payload()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode activation request: %v", err)
+	}
+	if !keyCrypto.VerifyActivationMAC(ar.MAC, payload) {
+		return nil, nil, fmt.Errorf("activation request was tampered with or created by another application")
+	}
+
+	if req.ProductName == "" {
+		req.ProductName = ar.ProductName
+	}
+	if req.ProductName != ar.ProductName {
+		return nil, nil, fmt.Errorf("activation request is for product %q, not %q", ar.ProductName, req.ProductName)
+	}
+
+	pcid, err := hardware.ParsePCID(ar.PCId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	license, err := m.newLicense(req, pcid)
+	if err != nil {
+		return nil, nil, err
+	}
+	license.ActivationNonce = ar.Nonce
+	license.Fingerprints = ar.Fingerprints
+
+	if err := m.issueLicense(license); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := m.sealLicense(license)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, license, nil
+}
+
+// Activate verifies a license file issued for this machine by IssueActivation or Issue
+// and installs it for its product. An existing license for the product is replaced,
+// which allows renewals.
+func (m *Manager) Activate(data []byte) (*License, error) {
+	header, _, err := crypto.ParseContainer(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read license file: %v", err)
+	}
+	if header.Product == "" {
+		return nil, fmt.Errorf("license file does not record its product and cannot be activated")
+	}
+	productName := header.Product
+
+	license, err := m.decodeLicense(data, productName, m.PCID)
+	if err != nil {
+		return nil, fmt.Errorf("activation failed for product %s: %w", productName, err)
+	}
+
+	// Issued licenses are unused; activating a copy of an installed one would reset its usage
+	if license.IsActivated || license.Generation > 0 {
+		return nil, fmt.Errorf("activation failed for product %s: %w - the license has already been used", productName, ErrReplayed)
+	}
+
+	// Licenses answering an activation request must match the pending request.
+	// Licenses issued directly for a PC ID carry no nonce and are installed as is.
+	pendingFile := ""
+	if license.ActivationNonce != "" {
+		pendingFile, err = m.config.GetActivationFilePathForProduct(productName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get activation file path: %v", err)
+		}
+		sealed, err := os.ReadFile(pendingFile)
+		if err != nil {
+			return nil, fmt.Errorf("no pending activation request for product %s", productName)
+		}
+		nonce, _, err := m.crypto.Open(sealed, productName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read activation state: %v", err)
+		}
+		if license.ActivationNonce != string(nonce) {
+			return nil, fmt.Errorf("license does not answer the pending activation request for product %s", productName)
+		}
+	}
+
+	unlock, err := m.lock(productName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := m.checkInstall(license); err != nil {
+		return nil, fmt.Errorf("activation failed for product %s: %w", productName, err)
+	}
+	if err := m.saveLicense(license); err != nil {
+		return nil, fmt.Errorf("failed to save license: %v", err)
+	}
+
+	if pendingFile != "" {
+		if err := os.Remove(pendingFile); err != nil {
+			return nil, fmt.Errorf("failed to clear activation state: %v", err)
+		}
+	}
+
+	return license, nil
+}
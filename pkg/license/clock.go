@@ -0,0 +1,43 @@
+package license
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AmrEsam0/license-manager/pkg/clock"
+	"github.com/AmrEsam0/license-manager/pkg/config"
+	"github.com/AmrEsam0/license-manager/pkg/crypto"
+)
+
+// SetClock replaces the clock used for expiry and usage tracking, for example with a
+// clock.Trusted that prefers a time server over the OS clock
+func (m *Manager) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// now returns the current time of the manager's clock
+func (m *Manager) now() time.Time {
+	return m.clock.Now()
+}
+
+// configuredClock returns the system clock, or a trusted time chain of the configured time
+// server and signed timestamp file, asked in that order
+func configuredClock(cfg *config.Config) (clock.Clock, error) {
+	var sources []clock.TimeSource
+	if cfg.TimeServer != "" {
+		sources = append(sources, &clock.HTTPDateSource{URL: cfg.TimeServer})
+	}
+	if cfg.TimestampFile != "" {
+		publicKey, err := crypto.ParsePublicKey(cfg.TimestampKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp key: %v", err)
+		}
+		sources = append(sources, &clock.SignedTimestampSource{Path: cfg.TimestampFile, PublicKey: publicKey})
+	}
+	if len(sources) == 0 {
+		return clock.System{}, nil
+	}
+	// Validations read the clock once, but List and repeated checks would ask the time server
+	// each time, which stalls when it is unreachable
+	return &clock.Trusted{Sources: sources, MaxAge: time.Minute}, nil
+}
@@ -0,0 +1,83 @@
+package license
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// HasFeature reports whether the product's license grants a feature flag.
+// It verifies the license without updating usage tracking; an invalid or expired
+// license grants no features and returns an error explaining why.
+func (m *Manager) HasFeature(productName, feature string) (bool, error) {
+	license, err := m.entitledLicense(productName)
+	if err != nil {
+		return false, err
+	}
+	return license.HasFeature(feature), nil
+}
+
+// Limit returns a numeric limit from the product's license, such as "max_projects".
+// The boolean is false when the license does not define the limit.
+func (m *Manager) Limit(productName, name string) (int, bool, error) {
+	license, err := m.entitledLicense(productName)
+	if err != nil {
+		return 0, false, err
+	}
+	value, ok := license.Limits[name]
+	return value, ok, nil
+}
+
+// Metadata returns a free-form metadata value from the product's license
+func (m *Manager) Metadata(productName, key string) (string, bool, error) {
+	license, err := m.entitledLicense(productName)
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := license.Metadata[key]
+	return value, ok, nil
+}
+
+// HasFeature reports whether the license grants a feature flag
+func (l *License) HasFeature(feature string) bool {
+	return slices.Contains(l.Features, feature)
+}
+
+// entitledLicense verifies a product's license like a read-only validation for entitlement lookups
+func (m *Manager) entitledLicense(productName string) (*License, error) {
+	license, _, err := m.readAndVerifyLicense(productName, m.PCID, true, m.now())
+	if err != nil {
+		return nil, fmt.Errorf("license validation failed for product %s: %w", productName, err)
+	}
+	return license, nil
+}
+
+// normalizeFeatures trims, sorts and de-duplicates feature flags so the encoded claims are stable
+func normalizeFeatures(features []string) []string {
+	var result []string
+	for _, feature := range features {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			result = append(result, feature)
+		}
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// validateEntitlements rejects empty names and negative limits
+func validateEntitlements(license *License) error {
+	for name, value := range license.Limits {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("limit name cannot be empty")
+		}
+		if value < 0 {
+			return fmt.Errorf("limit %s cannot be negative", name)
+		}
+	}
+	for key := range license.Metadata {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("metadata key cannot be empty")
+		}
+	}
+	return nil
+}
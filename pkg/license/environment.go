@@ -0,0 +1,56 @@
+package license
+
+import (
+	"fmt"
+
+	"github.com/AmrEsam0/license-manager/pkg/config"
+	"github.com/AmrEsam0/license-manager/pkg/hardware"
+)
+
+// policyRank orders environment policies from most to least permissive
+var policyRank = map[config.EnvironmentPolicy]int{
+	config.PolicyAllow:     0,
+	config.PolicyWarn:      1,
+	config.PolicyAlternate: 2,
+	config.PolicyRefuse:    3,
+}
+
+// Environment returns the container and virtual machine detection this manager applies
+// its environment policy to
+func (m *Manager) Environment() hardware.Environment {
+	return m.environment
+}
+
+// EnvironmentPolicy returns the policy in effect for the detected environment
+func (m *Manager) EnvironmentPolicy() config.EnvironmentPolicy {
+	return environmentPolicy(m.config, m.environment)
+}
+
+// environmentPolicy returns the strictest policy that applies to env.
+// A container inside a virtual machine is subject to both policies.
+func environmentPolicy(cfg *config.Config, env hardware.Environment) config.EnvironmentPolicy {
+	policy := config.PolicyAllow
+	if env.IsVirtual() && policyRank[cfg.VMPolicy] > policyRank[policy] {
+		policy = cfg.VMPolicy
+	}
+	if env.IsContainer() && policyRank[cfg.ContainerPolicy] > policyRank[policy] {
+		policy = cfg.ContainerPolicy
+	}
+	return policy
+}
+
+// checkEnvironment refuses to bind licenses to this machine when the policy says so
+func (m *Manager) checkEnvironment() error {
+	if m.EnvironmentPolicy() == config.PolicyRefuse {
+		return fmt.Errorf("licenses cannot be used in a %s", m.environment)
+	}
+	return nil
+}
+
+// environmentWarnings returns the validation warnings required by the warn policy
+func (m *Manager) environmentWarnings() []string {
+	if m.EnvironmentPolicy() != config.PolicyWarn {
+		return nil
+	}
+	return []string{fmt.Sprintf("running in a %s - the PC ID may change when it is recreated or cloned", m.environment)}
+}
@@ -0,0 +1,92 @@
+package license
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Validation failures wrap one of these errors, so callers can test for them with errors.Is
+// instead of matching messages. ReasonOf maps them to reason codes.
+var (
+	// ErrNotFound means the product has no license installed
+	ErrNotFound = errors.New("license file not found")
+	// ErrCorrupted means the license file cannot be decrypted or parsed, was tampered with,
+	// or belongs to another product
+	ErrCorrupted = errors.New("license file is corrupted")
+	// ErrWrongMachine means the license was issued for another PC
+	ErrWrongMachine = errors.New("license is not valid for this PC")
+	// ErrSerialMismatch means the serial does not cover the license claims
+	ErrSerialMismatch = errors.New("license serial is invalid")
+	// ErrExpired means the license is past its expiry and grace period
+	ErrExpired = errors.New("license has expired")
+	// ErrClockRollback means the system clock is behind the license's last recorded use
+	ErrClockRollback = errors.New("system date/time appears to have been rolled back")
+	// ErrRevoked means the license was revoked on this machine
+	ErrRevoked = errors.New("license has been revoked")
+	// ErrReplayed means the license file is an older copy than the last one recorded
+	ErrReplayed = errors.New("license file was restored from an older copy")
+)
+
+// Reason is a machine-readable validation failure code
+type Reason string
+
+const (
+	// ReasonNone is the reason of a valid license
+	ReasonNone           Reason = ""
+	ReasonNotFound       Reason = "not_found"
+	ReasonCorrupted      Reason = "corrupted"
+	ReasonWrongMachine   Reason = "wrong_machine"
+	ReasonSerialMismatch Reason = "serial_mismatch"
+	ReasonExpired        Reason = "expired"
+	ReasonClockRollback  Reason = "clock_rollback"
+	ReasonRevoked        Reason = "revoked"
+	ReasonReplayed       Reason = "replayed"
+	// ReasonInvalid covers other failures, such as a license that is not valid yet
+	// or an environment policy that refuses licenses
+	ReasonInvalid Reason = "invalid"
+)
+
+// reasons pairs each sentinel error with its reason code
+var reasons = []struct {
+	err    error
+	reason Reason
+}{
+	{ErrNotFound, ReasonNotFound},
+	{ErrCorrupted, ReasonCorrupted},
+	{ErrWrongMachine, ReasonWrongMachine},
+	{ErrSerialMismatch, ReasonSerialMismatch},
+	{ErrExpired, ReasonExpired},
+	{ErrClockRollback, ReasonClockRollback},
+	{ErrRevoked, ReasonRevoked},
+	{ErrReplayed, ReasonReplayed},
+}
+
+// ReasonOf returns the reason code of a validation error, ReasonNone for nil
+func ReasonOf(err error) Reason {
+	if err == nil {
+		return ReasonNone
+	}
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return ReasonInvalid
+}
+
+// revocation is stored in place of a revoked license, so that validation can tell a revoked
+// license from a corrupted one. It is sealed like a license and cannot be forged without the
+// master key.
+type revocation struct {
+	Revoked     bool      `json:"revoked"`
+	ProductName string    `json:"product_name"`
+	Serial      string    `json:"serial,omitempty"`
+	RevokedAt   time.Time `json:"revoked_at"`
+}
+
+// isRevocation reports whether decrypted license file contents are a revocation record
+func isRevocation(data []byte) bool {
+	var r revocation
+	return json.Unmarshal(data, &r) == nil && r.Revoked
+}
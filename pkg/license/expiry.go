@@ -0,0 +1,170 @@
+package license
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Mode returns the expiry mode, treating licenses created before modes existed as usage-day licenses
+func (l *License) Mode() ExpiryMode {
+	if l.ExpiryMode == "" {
+		return ExpiryUsageDays
+	}
+	return l.ExpiryMode
+}
+
+// RemainingDays returns the days left before the license expires at the given time.
+// Calendar days are rounded up; hybrid licenses report whichever limit is closer.
+// Lifetime licenses report 0 and should be checked with IsLifetime.
+func (l *License) RemainingDays(now time.Time) int {
+	if l.IsLifetime {
+		return 0
+	}
+
+	usageRemaining := max(l.MaxDays-len(l.UsageHistory), 0)
+	calendarRemaining := 0
+	if l.NotAfter != nil {
+		calendarRemaining = max(int(math.Ceil(l.NotAfter.Sub(now).Hours()/24)), 0)
+	}
+
+	switch l.Mode() {
+	case ExpiryCalendar:
+		return calendarRemaining
+	case ExpiryHybrid:
+		return min(usageRemaining, calendarRemaining)
+	default:
+		return usageRemaining
+	}
+}
+
+// RemainingDays returns the days left before the license expires at the time of the
+// manager's clock, for licenses read with View
+func (m *Manager) RemainingDays(license *License) int {
+	return license.RemainingDays(m.now())
+}
+
+// checkWindow rejects use before NotBefore. It runs before usage tracking so a
+// license that is not yet valid does not consume days.
+func (l *License) checkWindow(now time.Time) error {
+	if l.NotBefore != nil && now.Before(*l.NotBefore) {
+		return fmt.Errorf("license is not valid before %s", l.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// overdueDays returns how many days the license is past its expiry, 0 while it has not expired.
+// Hybrid licenses count from whichever limit was reached first.
+func (l *License) overdueDays(now time.Time) int {
+	if l.IsLifetime {
+		return 0
+	}
+	overdue := 0
+	if l.Mode() != ExpiryCalendar {
+		overdue = max(len(l.UsageHistory)-l.MaxDays, 0)
+	}
+	if l.Mode() != ExpiryUsageDays && l.NotAfter != nil && now.After(*l.NotAfter) {
+		overdue = max(overdue, int(math.Ceil(now.Sub(*l.NotAfter).Hours()/24)))
+	}
+	return overdue
+}
+
+// checkExpired fails once the license is past its expiry and its grace period
+func (l *License) checkExpired(now time.Time) error {
+	if l.overdueDays(now) <= l.GraceDays {
+		return nil
+	}
+	if l.Mode() != ExpiryCalendar && len(l.UsageHistory)-l.MaxDays > l.GraceDays {
+		return fmt.Errorf("%w - used %d days out of %d allowed", ErrExpired, len(l.UsageHistory), l.MaxDays)
+	}
+	return fmt.Errorf("%w - valid until %s", ErrExpired, l.NotAfter.Format(time.RFC3339))
+}
+
+// Status reports the lifecycle status at the given time, and the grace days left
+// when the license is in its grace period. warnDays is the threshold for StatusExpiringSoon.
+func (l *License) Status(now time.Time, warnDays int) (LicenseStatus, int) {
+	overdue := l.overdueDays(now)
+	switch {
+	case overdue > l.GraceDays:
+		return StatusExpired, 0
+	case overdue > 0:
+		return StatusGracePeriod, l.GraceDays - overdue
+	case !l.IsLifetime && l.RemainingDays(now) <= warnDays:
+		return StatusExpiringSoon, 0
+	default:
+		return StatusValid, 0
+	}
+}
+
+// applyExpiry copies and validates the expiry settings of a create request
+func applyExpiry(license *License, req CreateLicenseRequest) error {
+	mode := req.ExpiryMode
+	if mode == "" {
+		mode = ExpiryUsageDays
+	}
+
+	switch mode {
+	case ExpiryUsageDays:
+		if !req.NotAfter.IsZero() {
+			return fmt.Errorf("NotAfter requires the calendar or hybrid expiry mode")
+		}
+	case ExpiryCalendar, ExpiryHybrid:
+		if req.NotAfter.IsZero() {
+			return fmt.Errorf("%s expiry requires NotAfter", mode)
+		}
+		if license.IsLifetime {
+			return fmt.Errorf("%s expiry cannot be combined with a lifetime license", mode)
+		}
+		if mode == ExpiryCalendar {
+			license.MaxDays = 0
+		} else if license.MaxDays <= 0 {
+			return fmt.Errorf("hybrid expiry requires a positive MaxDays")
+		}
+	default:
+		return fmt.Errorf("unknown expiry mode %q", mode)
+	}
+
+	if !req.NotBefore.IsZero() && !req.NotAfter.IsZero() && !req.NotBefore.Before(req.NotAfter) {
+		return fmt.Errorf("NotBefore must be before NotAfter")
+	}
+
+	// Usage-day licenses keep an empty mode so their encoded claims stay unchanged
+	if mode != ExpiryUsageDays {
+		license.ExpiryMode = mode
+	}
+	if !req.NotBefore.IsZero() {
+		notBefore := req.NotBefore.UTC()
+		license.NotBefore = &notBefore
+	}
+	if !req.NotAfter.IsZero() {
+		notAfter := req.NotAfter.UTC()
+		license.NotAfter = &notAfter
+	}
+	return nil
+}
+
+// applyGrace sets the grace period of a new license, falling back to the configured default
+func applyGrace(license *License, req CreateLicenseRequest, defaultGraceDays int) error {
+	if req.GraceDays < 0 {
+		return fmt.Errorf("GraceDays must not be negative")
+	}
+	if req.NoGrace && req.GraceDays > 0 {
+		return fmt.Errorf("NoGrace cannot be combined with GraceDays")
+	}
+	if license.IsLifetime {
+		return nil
+	}
+	license.GraceDays = req.GraceDays
+	if license.GraceDays == 0 && !req.NoGrace {
+		license.GraceDays = defaultGraceDays
+	}
+	return nil
+}
+
+// formatClaimTime encodes an optional time for the license claims
+func formatClaimTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
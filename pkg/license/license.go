@@ -2,13 +2,15 @@ package license
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"maps"
 	"time"
 
 	"slices"
 
+	"github.com/AmrEsam0/license-manager/pkg/clock"
 	"github.com/AmrEsam0/license-manager/pkg/config"
 	"github.com/AmrEsam0/license-manager/pkg/crypto"
 	"github.com/AmrEsam0/license-manager/pkg/hardware"
@@ -16,70 +18,225 @@ import (
 
 // Manager handles all license operations
 type Manager struct {
-	config  *config.Config
-	crypto  *crypto.CryptoManager
-	pcidGen *hardware.PCIDGenerator
-	PCID    string
+	config        *config.Config
+	crypto        *crypto.CryptoManager
+	store         Store
+	stateStore    Store
+	clock         clock.Clock
+	anchors       []TimeAnchor
+	fingerprinter hardware.Fingerprinter
+	PCID          string
+
+	// fingerprints of the individual hardware components behind PCID
+	fingerprints map[string]string
+	// legacyPCIDs this machine had under earlier PC ID schemes
+	legacyPCIDs []string
+	// environment is the detected container or virtual machine, see Environment
+	environment hardware.Environment
 }
 
 // NewManager creates a new license manager
 func NewManager() (*Manager, error) {
-	cfg := config.LoadConfig()
-	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %v", err)
+	cryptoMgr, err := crypto.NewCryptoManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize crypto manager: %v", err)
 	}
+	return newManager(cryptoMgr)
+}
 
-	cryptoMgr, err := crypto.NewCryptoManager()
+// NewManagerWithKey creates a new license manager with a provided master key
+func NewManagerWithKey(masterKey string) (*Manager, error) {
+	cryptoMgr, err := crypto.NewCryptoManagerWithKey(masterKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize crypto manager: %v", err)
 	}
+	return newManager(cryptoMgr)
+}
 
-	pcidGen := hardware.NewPCIDGenerator()
-	if !pcidGen.IsSupported() {
-		return nil, fmt.Errorf("unsupported platform: %s", pcidGen.GetSupportedPlatforms())
+// NewManagerWithKeyProvider creates a new license manager with a master key from a key provider
+func NewManagerWithKeyProvider(provider crypto.KeyProvider) (*Manager, error) {
+	cryptoMgr, err := crypto.NewCryptoManagerWithProvider(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize crypto manager: %v", err)
 	}
+	return newManager(cryptoMgr)
+}
 
-	PCID, err := pcidGen.Generate()
+// NewManagerWithKeyring creates a license manager that encrypts with the active master key
+// and can still open licenses encrypted with any of the retired keys
+func NewManagerWithKeyring(activeKey string, retiredKeys ...string) (*Manager, error) {
+	cryptoMgr, err := crypto.NewCryptoManagerWithKeyring(activeKey, retiredKeys...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate PC ID: %v", err)
+		return nil, fmt.Errorf("failed to initialize crypto manager: %v", err)
 	}
+	return newManager(cryptoMgr)
+}
 
-	return &Manager{
-		config:  cfg,
-		crypto:  cryptoMgr,
-		pcidGen: pcidGen,
-		PCID:    PCID,
-	}, nil
+// NewManagerWithSigningKey creates a license manager for issuing tooling.
+// Licenses it creates are signed with the hex-encoded Ed25519 private key.
+func NewManagerWithSigningKey(masterKey, privateKey string) (*Manager, error) {
+	cryptoMgr, err := crypto.NewCryptoManagerWithSigningKey(masterKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize crypto manager: %v", err)
+	}
+	return newManager(cryptoMgr)
 }
 
-// NewManagerWithKey creates a new license manager with a provided master key
-func NewManagerWithKey(masterKey string) (*Manager, error) {
-	cfg := config.LoadConfig()
-	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %v", err)
+// NewManagerWithPublicKey creates a license manager for shipped binaries.
+// It only accepts licenses signed by the matching private key and cannot create licenses.
+func NewManagerWithPublicKey(masterKey, publicKey string) (*Manager, error) {
+	cryptoMgr, err := crypto.NewCryptoManagerWithPublicKey(masterKey, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize crypto manager: %v", err)
 	}
+	return newManager(cryptoMgr)
+}
 
-	cryptoMgr, err := crypto.NewCryptoManagerWithKey(masterKey)
+// ManagerOptions configures NewManagerWithOptions.
+// Zero values fall back to the same environment-based defaults as NewManager.
+type ManagerOptions struct {
+	// MasterKey is used when set, otherwise KeyProvider, otherwise the environment
+	MasterKey   string
+	KeyProvider crypto.KeyProvider
+
+	// Fingerprinter identifies this machine, the hardware PC ID generator when nil
+	Fingerprinter hardware.Fingerprinter
+
+	// Config replaces the configuration loaded from the environment
+	Config *config.Config
+
+	// Environment replaces container and virtual machine detection when set
+	Environment *hardware.Environment
+
+	// Store persists license files, a FileStore in the license directory when nil
+	Store Store
+
+	// Clock tells the time for expiry and usage tracking, the system clock when nil
+	Clock clock.Clock
+
+	// StateStore mirrors the usage generation of each license to detect restored copies.
+	// When nil it is <product>.state files in the state directory. It is required when Store
+	// is set, and must not be backed up and restored with Store.
+	StateStore Store
+
+	// DisableReplayDetection runs without a state store, so restored license copies and clock
+	// rollbacks behind the usage record go unnoticed. It lets Store be set without StateStore.
+	DisableReplayDetection bool
+
+	// TimeAnchors replace the system file anchor in clock rollback detection when set.
+	// The license file and its usage record are always checked.
+	TimeAnchors []TimeAnchor
+}
+
+// NewManagerWithOptions creates a license manager with explicit key, machine identification
+// and configuration, for containers, CI and tests where the defaults do not fit
+func NewManagerWithOptions(opts ManagerOptions) (*Manager, error) {
+	switch {
+	case opts.StateStore != nil && opts.DisableReplayDetection:
+		return nil, fmt.Errorf("StateStore cannot be set with DisableReplayDetection")
+	case opts.Store != nil && opts.StateStore == nil && !opts.DisableReplayDetection:
+		return nil, fmt.Errorf("a custom Store needs a StateStore kept apart from it, or DisableReplayDetection")
+	}
+
+	var cryptoMgr *crypto.CryptoManager
+	var err error
+	switch {
+	case opts.MasterKey != "":
+		cryptoMgr, err = crypto.NewCryptoManagerWithKey(opts.MasterKey)
+	case opts.KeyProvider != nil:
+		cryptoMgr, err = crypto.NewCryptoManagerWithProvider(opts.KeyProvider)
+	default:
+		cryptoMgr, err = crypto.NewCryptoManager()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize crypto manager: %v", err)
 	}
 
-	pcidGen := hardware.NewPCIDGenerator()
-	if !pcidGen.IsSupported() {
-		return nil, fmt.Errorf("unsupported platform: %s", pcidGen.GetSupportedPlatforms())
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = config.LoadConfig()
+	}
+	env := hardware.DetectEnvironment()
+	if opts.Environment != nil {
+		env = *opts.Environment
+	}
+	m, err := newManagerWithFingerprinter(cryptoMgr, cfg, opts.Fingerprinter, env)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Store != nil {
+		m.store = opts.Store
+	}
+	if opts.StateStore != nil {
+		m.stateStore = opts.StateStore
+	}
+	if opts.DisableReplayDetection {
+		m.stateStore = nil
+	}
+	if opts.Clock != nil {
+		m.clock = opts.Clock
+	}
+	if opts.TimeAnchors != nil {
+		m.anchors = append(m.anchors[:1:1], opts.TimeAnchors...)
+	}
+	return m, nil
+}
+
+// newManager wires configuration and hardware identification around a crypto manager
+func newManager(cryptoMgr *crypto.CryptoManager) (*Manager, error) {
+	return newManagerWithFingerprinter(cryptoMgr, config.LoadConfig(), nil, hardware.DetectEnvironment())
+}
+
+// newManagerWithFingerprinter identifies this machine with fingerprinter, or when it is nil
+// with the hardware PC ID generator, or the host ID file when the environment policy is alternate
+func newManagerWithFingerprinter(cryptoMgr *crypto.CryptoManager, cfg *config.Config, fingerprinter hardware.Fingerprinter, env hardware.Environment) (*Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
 	}
 
-	currentPC, err := pcidGen.Generate()
+	if fingerprinter == nil && environmentPolicy(cfg, env) == config.PolicyAlternate {
+		fingerprinter = &hardware.FileFingerprinter{Path: cfg.HostIDFile, Name: "host"}
+	}
+	if fingerprinter == nil {
+		pcidGen := hardware.NewPCIDGenerator()
+		if !pcidGen.IsSupported() {
+			return nil, fmt.Errorf("unsupported platform: %s", pcidGen.GetSupportedPlatforms())
+		}
+		fingerprinter = pcidGen
+	}
+
+	components, err := fingerprinter.Components()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate PC ID: %v", err)
 	}
 
-	return &Manager{
-		config:  cfg,
-		crypto:  cryptoMgr,
-		pcidGen: pcidGen,
-		PCID:    currentPC,
-	}, nil
+	clk, err := configuredClock(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		config:        cfg,
+		crypto:        cryptoMgr,
+		store:         NewFileStore(cfg),
+		stateStore:    &stateFileStore{config: cfg},
+		clock:         clk,
+		fingerprinter: fingerprinter,
+		PCID:          hardware.HashComponents(components),
+		fingerprints:  hardware.Fingerprints(components),
+		environment:   env,
+	}
+	m.anchors = m.defaultAnchors()
+	if legacy, ok := fingerprinter.(hardware.LegacyIdentifier); ok {
+		m.legacyPCIDs = legacy.LegacyPCIDs()
+	}
+	return m, nil
+}
+
+// SetKDF selects the key derivation function for license files written from now on.
+// Existing files keep working because their KDF parameters are recorded in the file header.
+func (m *Manager) SetKDF(params crypto.KDFParams) error {
+	return m.crypto.SetKDF(params)
 }
 
 // GetPCID returns the current PC ID
@@ -89,14 +246,84 @@ func (m *Manager) GetPCID() string {
 
 // Create creates a new license with the given parameters
 func (m *Manager) Create(req CreateLicenseRequest) (*License, error) {
-	licenseFile, err := m.config.GetLicenseFilePathForProduct(req.ProductName)
+	unlock, err := m.lock(req.ProductName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get license file path: %v", err)
+		return nil, err
 	}
+	defer unlock()
 
 	// Check if license file already exists
-	if _, err := os.Stat(licenseFile); err == nil {
+	if _, err := m.store.Get(req.ProductName); err == nil {
 		return nil, fmt.Errorf("license file already exists")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read license store: %v", err)
+	}
+
+	pcid, fingerprints, err := m.targetMachine(req)
+	if err != nil {
+		return nil, err
+	}
+
+	license, err := m.newLicense(req, pcid)
+	if err != nil {
+		return nil, err
+	}
+	license.Fingerprints = fingerprints
+
+	if err := m.issueLicense(license); err != nil {
+		return nil, err
+	}
+
+	if err := m.saveLicense(license); err != nil {
+		return nil, fmt.Errorf("failed to save license: %v", err)
+	}
+
+	return license, nil
+}
+
+// Issue creates a license like Create but returns the license file contents instead of
+// writing them to the license directory, for delivery to the machine named by req.TargetPCID
+func (m *Manager) Issue(req CreateLicenseRequest) ([]byte, *License, error) {
+	pcid, fingerprints, err := m.targetMachine(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	license, err := m.newLicense(req, pcid)
+	if err != nil {
+		return nil, nil, err
+	}
+	license.Fingerprints = fingerprints
+
+	if err := m.issueLicense(license); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := m.sealLicense(license)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, license, nil
+}
+
+// targetMachine returns the validated PC ID a create request binds to, and the component
+// fingerprints when the license is for this machine
+func (m *Manager) targetMachine(req CreateLicenseRequest) (string, map[string]string, error) {
+	if req.TargetPCID == "" {
+		if err := m.checkEnvironment(); err != nil {
+			return "", nil, err
+		}
+		return m.PCID, m.fingerprints, nil
+	}
+	pcid, err := hardware.ParsePCID(req.TargetPCID)
+	return pcid, nil, err
+}
+
+// newLicense builds an unissued license for a PC ID from a create request
+func (m *Manager) newLicense(req CreateLicenseRequest, pcid string) (*License, error) {
+	// A verify-only manager must never be able to mint licenses
+	if m.crypto.IsAsymmetric() && !m.crypto.CanSign() {
+		return nil, fmt.Errorf("cannot create licenses without the signing key")
 	}
 
 	// Handle lifetime license
@@ -106,18 +333,10 @@ func (m *Manager) Create(req CreateLicenseRequest) (*License, error) {
 		maxDays = m.config.LifetimeDays
 	}
 
-	// Determine PCID to use
-	pcid := m.PCID
-
-	// generate a new serial number
-
-	serial := m.crypto.GenerateSerial(pcid, req.ProductName, maxDays)
-
 	license := &License{
-		Serial:       serial,
 		PCId:         pcid,
 		ProductName:  req.ProductName,
-		CreatedAt:    time.Now(),
+		CreatedAt:    m.now(),
 		MaxDays:      maxDays,
 		IsLifetime:   isLifetime,
 		LastUsedDate: "",
@@ -126,67 +345,125 @@ func (m *Manager) Create(req CreateLicenseRequest) (*License, error) {
 		IsActivated:  false,
 		UsageHistory: []string{},
 		UsageMap:     make(map[string]bool),
+		Features:     normalizeFeatures(req.Features),
+		Limits:       req.Limits,
+		Metadata:     req.Metadata,
+
+		ReplayProtected: true,
 	}
 
-	if err := m.saveLicense(license, licenseFile); err != nil {
-		return nil, fmt.Errorf("failed to save license: %v", err)
+	if err := validateEntitlements(license); err != nil {
+		return nil, err
+	}
+
+	if err := applyExpiry(license, req); err != nil {
+		return nil, err
+	}
+
+	if err := applyGrace(license, req, m.config.GraceDays); err != nil {
+		return nil, err
 	}
 
 	return license, nil
 }
 
+// issueLicense generates the serial and, when a signing key is available, the signature
+func (m *Manager) issueLicense(license *License) error {
+	// generate a new serial number covering every issued field
+	if err := m.authenticateLicense(license); err != nil {
+		return fmt.Errorf("failed to generate serial: %v", err)
+	}
+
+	if m.crypto.CanSign() {
+		if err := m.signLicense(license); err != nil {
+			return fmt.Errorf("failed to sign license: %v", err)
+		}
+	}
+	return nil
+}
+
 // ValidateProduct validates a specific product's license and updates usage tracking
 func (m *Manager) Validate(productName string) (*ValidationResult, error) {
-	licenseFile, err := m.config.GetLicenseFilePathForProduct(productName)
-	if err != nil {
-		return &ValidationResult{
-			IsValid:      false,
-			ErrorMessage: fmt.Sprintf("failed to get license file path for product %s: %v", productName, err),
-		}, nil
-	}
+	return m.ValidateWithOptions(productName, ValidateOptions{})
+}
 
-	license, err := m.readAndVerifyLicense(licenseFile, m.PCID)
+// ValidateWithOptions validates a specific product's license, see ValidateOptions
+func (m *Manager) ValidateWithOptions(productName string, opts ValidateOptions) (*ValidationResult, error) {
+	// One reading of the clock for the whole decision; a trusted clock may ask a time server
+	now := m.now()
+	license, updated, err := m.readAndVerifyLicense(productName, m.PCID, opts.ReadOnly, now)
 	if err != nil {
+		status := statusOf(err)
+		err = fmt.Errorf("license validation failed for product %s: %w", productName, err)
 		return &ValidationResult{
 			IsValid:      false,
-			ErrorMessage: fmt.Sprintf("license validation failed for product %s: %v", productName, err),
+			ErrorMessage: err.Error(),
+			Status:       status,
+			Reason:       ReasonOf(err),
+			Err:          err,
 		}, nil
 	}
 
+	// The status is that of the license after this run, even when a read-only validation
+	// returns the license as stored
+	status, graceRemaining := updated.Status(now, m.config.WarnDays)
 	return &ValidationResult{
-		IsValid: true,
-		License: license,
+		IsValid:            true,
+		License:            license,
+		Status:             status,
+		RemainingDays:      updated.RemainingDays(now),
+		GraceDaysRemaining: graceRemaining,
+		DriftedComponents:  m.hardwareDrift(license, m.PCID),
+		Warnings:           m.environmentWarnings(),
 	}, nil
 }
 
+// statusOf returns the status of a license that failed validation with err
+func statusOf(err error) LicenseStatus {
+	switch {
+	case errors.Is(err, ErrExpired):
+		return StatusExpired
+	case errors.Is(err, ErrClockRollback):
+		return StatusClockRollback
+	default:
+		return StatusInvalid
+	}
+}
+
 // GetProductInfo returns read-only license information for a specific product
 func (m *Manager) GetInfo(productName string) (*LicenseInfo, error) {
-	result, err := m.Validate(productName)
+	result, err := m.ValidateWithOptions(productName, ValidateOptions{ReadOnly: true})
 	if err != nil {
 		return nil, err
 	}
 
 	if !result.IsValid {
-		return nil, fmt.Errorf("%s", result.ErrorMessage)
+		return nil, result.Err
 	}
 
 	license := result.License
-	remainingDays := 0
-	if !license.IsLifetime {
-		remainingDays = max(license.MaxDays-len(license.UsageHistory), 0)
-	}
 
 	info := &LicenseInfo{
 		ProductName:   license.ProductName,
 		IsLifetime:    license.IsLifetime,
 		MaxDays:       license.MaxDays,
 		UsedDays:      len(license.UsageHistory),
-		RemainingDays: remainingDays,
+		RemainingDays: result.RemainingDays,
 		RunCount:      license.RunCount,
 		FirstRunDate:  license.FirstRunDate,
 		LastUsedDate:  license.LastUsedDate,
 		UsageHistory:  license.UsageHistory,
+		Features:      license.Features,
+		Limits:        license.Limits,
+		Metadata:      license.Metadata,
+		ExpiryMode:    license.Mode(),
+		NotBefore:     license.NotBefore,
+		NotAfter:      license.NotAfter,
+		GraceDays:     license.GraceDays,
 		IsValid:       true,
+
+		Status:             result.Status,
+		GraceDaysRemaining: result.GraceDaysRemaining,
 	}
 
 	return info, nil
@@ -196,189 +473,535 @@ func (m *Manager) GetInfo(productName string) (*LicenseInfo, error) {
 
 // ViewProduct retrieves the license for a specific product without updating usage
 func (m *Manager) View(productName string) (*License, error) {
-	licenseFile, err := m.config.GetLicenseFilePathForProduct(productName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get license file path for product %s: %v", productName, err)
+	encryptedData, err := m.store.Get(productName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no license for product %s: %w", productName, ErrNotFound)
 	}
-
-	encryptedData, err := os.ReadFile(licenseFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read license file for product %s: %v", productName, err)
 	}
 
-	data, err := m.crypto.Decrypt(encryptedData)
+	data, _, err := m.crypto.Open(encryptedData, productName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt license file for product %s: %v", productName, err)
+		return nil, fmt.Errorf("%w - failed to decrypt license file for product %s: %v", ErrCorrupted, productName, err)
+	}
+
+	if isRevocation(data) {
+		return nil, fmt.Errorf("license for product %s: %w", productName, ErrRevoked)
 	}
 
 	var license License
 	if err := json.Unmarshal(data, &license); err != nil {
-		return nil, fmt.Errorf("failed to parse license file for product %s: %v", productName, err)
+		return nil, fmt.Errorf("%w - failed to parse license file for product %s: %v", ErrCorrupted, productName, err)
 	}
 
 	if license.PCId != m.PCID {
-		return nil, fmt.Errorf("license for product %s is not valid for this PC %s - expected: %s", productName, m.PCID, license.PCId)
+		return nil, fmt.Errorf("%w - license for product %s is bound to %s, this PC is %s", ErrWrongMachine, productName, license.PCId, m.PCID)
 	}
 
 	return &license, nil
 }
 
+// Format returns the container header of a product's license file without decrypting it
+func (m *Manager) Format(productName string) (*crypto.Header, error) {
+	data, err := m.store.Get(productName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read license file for product %s: %v", productName, err)
+	}
+
+	header, _, err := crypto.ParseContainer(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse license file for product %s: %v", productName, err)
+	}
+	return header, nil
+}
+
 // RevokeProduct invalidates a specific product's license
 func (m *Manager) Revoke(productName string) error {
-	licenseFile, err := m.config.GetLicenseFilePathForProduct(productName)
+	if err := m.checkExists(productName); err != nil {
+		return fmt.Errorf("no license file found for product %s: %w", productName, err)
+	}
+	unlock, err := m.lock(productName)
 	if err != nil {
-		return fmt.Errorf("failed to get license file path for product %s: %v", productName, err)
+		return err
 	}
+	defer unlock()
 
 	// Check if license file exists
-	if _, err := os.Stat(licenseFile); os.IsNotExist(err) {
-		return fmt.Errorf("no license file found for product %s", productName)
+	existing, err := m.store.Get(productName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no license file found for product %s: %w", productName, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read license file for product %s: %v", productName, err)
+	}
+
+	// Replace the license with a sealed revocation record. Unlike random data, validation
+	// can tell it apart from a corrupted file and report the license as revoked.
+	record := revocation{Revoked: true, ProductName: productName, RevokedAt: m.now().UTC()}
+	var generation uint64
+	if data, _, err := m.crypto.Open(existing, productName); err == nil {
+		var license License
+		if json.Unmarshal(data, &license) == nil {
+			record.Serial = license.Serial
+			generation = license.Generation
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode revocation: %v", err)
+	}
+	sealed, err := m.crypto.Seal(data, productName)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt revocation: %v", err)
+	}
+
+	if err := m.store.Put(productName, sealed); err != nil {
+		return fmt.Errorf("failed to revoke license file for product %s: %v", productName, err)
+	}
+
+	if err := m.recordRevocation(productName, generation); err != nil {
+		return fmt.Errorf("failed to record revocation: %v", err)
+	}
+
+	return nil
+}
+
+// RekeyResult describes the outcome of re-encrypting one license file
+type RekeyResult struct {
+	Product string
+	// File is the license file path when licenses are kept in a FileStore
+	File     string
+	OldKeyID string
+	NewKeyID string
+	Err      error
+}
+
+// Rekey re-encrypts every license in the store under the active master key.
+// Files may be encrypted under any key in the keyring; usage state is preserved as-is.
+func (m *Manager) Rekey() ([]RekeyResult, error) {
+	products, err := m.listProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]RekeyResult, 0, len(products))
+	for _, product := range products {
+		result := RekeyResult{Product: product, NewKeyID: m.crypto.KeyID()}
+		if fileStore, ok := m.store.(*FileStore); ok {
+			result.File, _ = fileStore.Path(product)
+		}
+		result.OldKeyID, result.Err = m.rekeyFile(product)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// rekeyFile re-encrypts a single license file and returns the key ID it was encrypted with
+func (m *Manager) rekeyFile(productName string) (string, error) {
+	unlock, err := m.lock(productName)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	encryptedData, err := m.store.Get(productName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read license file: %v", err)
+	}
+
+	data, header, err := m.crypto.Open(encryptedData, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt license file: %v", err)
+	}
+
+	// Revocation records carry no serial; they are re-encrypted as they are
+	if isRevocation(data) {
+		sealed, err := m.crypto.Seal(data, productName)
+		if err != nil {
+			return header.KeyID, fmt.Errorf("failed to encrypt revocation: %v", err)
+		}
+		return header.KeyID, m.store.Put(productName, sealed)
+	}
+
+	var license License
+	if err := json.Unmarshal(data, &license); err != nil {
+		return header.KeyID, fmt.Errorf("failed to parse license file: %v", err)
 	}
 
-	// Corrupt the license file by overwriting it with random data
-	// This makes any copied versions of the license invalid
-	corruptData, err := m.crypto.GenerateRandomBytes(1024) // 1KB of random data
+	// Serials are keyed by the master key, so they must be re-issued as well
+	if err := m.verifySerial(&license, header); err != nil {
+		return header.KeyID, err
+	}
+	if err := m.authenticateLicense(&license); err != nil {
+		return header.KeyID, err
+	}
+
+	if err := m.saveLicense(&license); err != nil {
+		return header.KeyID, err
+	}
+	return header.KeyID, nil
+}
+
+// saveLicense encrypts and stores the license under its product
+func (m *Manager) saveLicense(license *License) error {
+	encryptedData, err := m.sealLicense(license)
 	if err != nil {
-		return fmt.Errorf("failed to generate corrupt data: %v", err)
+		return err
+	}
+
+	if err := m.store.Put(license.ProductName, encryptedData); err != nil {
+		return fmt.Errorf("failed to write license file: %v", err)
 	}
 
-	// Overwrite the license file with corrupt data
-	if err := os.WriteFile(licenseFile, corruptData, 0644); err != nil {
-		return fmt.Errorf("failed to corrupt license file for product %s: %v", productName, err)
+	if err := m.recordState(license); err != nil {
+		return fmt.Errorf("failed to record usage state: %v", err)
 	}
 
 	return nil
 }
 
-// saveLicense encrypts and saves the license to file
-func (m *Manager) saveLicense(license *License, filename string) error {
-	dir := filepath.Dir(filename)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+// lock takes the store's lock of a product when the store supports locking
+func (m *Manager) lock(productName string) (func(), error) {
+	locker, ok := m.store.(Locker)
+	if !ok {
+		return func() {}, nil
 	}
+	unlock, err := locker.Lock(productName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock license: %v", err)
+	}
+	return unlock, nil
+}
 
+// checkExists reports ErrNotFound before a product's lock is taken, so looking up a product
+// without a license leaves no lock file or license directory behind. Other read errors are
+// reported once the license is read under the lock.
+func (m *Manager) checkExists(productName string) error {
+	if _, err := m.store.Get(productName); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sealLicense encodes and encrypts a license into license file contents
+func (m *Manager) sealLicense(license *License) ([]byte, error) {
 	data, err := json.MarshalIndent(license, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal license: %v", err)
+		return nil, fmt.Errorf("failed to marshal license: %v", err)
 	}
 
-	encryptedData, err := m.crypto.Encrypt(data)
+	encryptedData, err := m.crypto.Seal(data, license.ProductName)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt license: %v", err)
+		return nil, fmt.Errorf("failed to encrypt license: %v", err)
 	}
+	return encryptedData, nil
+}
 
-	if err := os.WriteFile(filename, encryptedData, 0644); err != nil {
-		return fmt.Errorf("failed to write license file: %v", err)
+// claims returns the canonical encoding of the issuer-controlled license fields.
+// Struct fields marshal in declaration order and map keys are sorted, so the output is stable.
+func (l *License) claims() ([]byte, error) {
+	return json.Marshal(licenseClaims{
+		PCId:            l.PCId,
+		ProductName:     l.ProductName,
+		CreatedAt:       l.CreatedAt.UTC().Format(time.RFC3339Nano),
+		MaxDays:         l.MaxDays,
+		IsLifetime:      l.IsLifetime,
+		Features:        l.Features,
+		Limits:          l.Limits,
+		Metadata:        l.Metadata,
+		ExpiryMode:      l.ExpiryMode,
+		NotBefore:       formatClaimTime(l.NotBefore),
+		NotAfter:        formatClaimTime(l.NotAfter),
+		GraceDays:       l.GraceDays,
+		ActivationNonce: l.ActivationNonce,
+		Fingerprints:    l.Fingerprints,
+		ReplayProtected: l.ReplayProtected,
+	})
+}
+
+// authenticateLicense sets the versioned HMAC serial over the license claims
+func (m *Manager) authenticateLicense(license *License) error {
+	claims, err := license.claims()
+	if err != nil {
+		return err
+	}
+	serial, err := m.crypto.GenerateAuthenticator(claims)
+	if err != nil {
+		return fmt.Errorf("failed to generate serial: %v", err)
 	}
+	license.Serial = serial
+	return nil
+}
 
+// verifySerial checks the license serial with the key and KDF that produced the file.
+// Legacy serials are accepted while the migration window is open, and serials made with a
+// legacy scheme, a retired key or old KDF parameters are re-issued on the next save.
+func (m *Manager) verifySerial(license *License, header *crypto.Header) error {
+	keyCrypto, err := m.crypto.ForHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if crypto.IsLegacySerial(license.Serial) {
+		if !m.config.AllowLegacySerials {
+			return fmt.Errorf("%w - it uses a retired format", ErrSerialMismatch)
+		}
+		expectedSerial, err := keyCrypto.GenerateSerial(license.PCId, license.ProductName, license.MaxDays)
+		if err != nil {
+			return fmt.Errorf("failed to generate serial: %v", err)
+		}
+		if license.Serial != expectedSerial {
+			return ErrSerialMismatch
+		}
+		return m.authenticateLicense(license)
+	}
+
+	claims, err := license.claims()
+	if err != nil {
+		return fmt.Errorf("failed to encode license claims: %v", err)
+	}
+	if !keyCrypto.VerifyAuthenticator(license.Serial, claims) {
+		return ErrSerialMismatch
+	}
+	if keyCrypto != m.crypto {
+		return m.authenticateLicense(license)
+	}
 	return nil
 }
 
-// readAndVerifyLicense reads, decrypts, and verifies the license
-func (m *Manager) readAndVerifyLicense(filename, currentPcId string) (*License, error) {
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return nil, fmt.Errorf("license file not found")
+// signLicense signs the license claims with the issuing key
+func (m *Manager) signLicense(license *License) error {
+	claims, err := license.claims()
+	if err != nil {
+		return err
 	}
+	signature, err := m.crypto.Sign(claims)
+	if err != nil {
+		return err
+	}
+	license.Signature = signature
+	return nil
+}
 
-	encryptedData, err := os.ReadFile(filename)
+// verifyLicenseSignature checks the license claims against the embedded public key
+func (m *Manager) verifyLicenseSignature(license *License) error {
+	if license.Signature == "" {
+		return fmt.Errorf("license is not signed")
+	}
+	claims, err := license.claims()
+	if err != nil {
+		return fmt.Errorf("failed to encode license claims: %v", err)
+	}
+	if err := m.crypto.Verify(claims, license.Signature); err != nil {
+		return fmt.Errorf("license signature is invalid: %v", err)
+	}
+	return nil
+}
+
+// loadLicense reads, decrypts, and verifies the integrity and PC binding of a product's license
+// without touching usage tracking
+func (m *Manager) loadLicense(productName, currentPcId string) (*License, error) {
+	encryptedData, err := m.store.Get(productName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read license file: %v", err)
 	}
 
-	data, err := m.crypto.Decrypt(encryptedData)
+	return m.decodeLicense(encryptedData, productName, currentPcId)
+}
+
+// decodeLicense decrypts license file contents and verifies them for a product and PC.
+// When verification fails after the license was parsed, it is returned with the error for reporting.
+func (m *Manager) decodeLicense(encryptedData []byte, productName, currentPcId string) (*License, error) {
+	if err := m.checkEnvironment(); err != nil {
+		return nil, err
+	}
+
+	data, header, err := m.crypto.Open(encryptedData, productName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt license file (file may be corrupted): %v", err)
+		var versionErr *crypto.UnsupportedVersionError
+		if errors.As(err, &versionErr) {
+			return nil, fmt.Errorf("failed to read license file: %v", err)
+		}
+		var authErr *crypto.AuthenticationError
+		if errors.As(err, &authErr) {
+			return nil, fmt.Errorf("%w - tampered with or belongs to another product: %v", ErrCorrupted, err)
+		}
+		return nil, fmt.Errorf("%w - failed to decrypt: %v", ErrCorrupted, err)
+	}
+
+	if isRevocation(data) {
+		return nil, ErrRevoked
 	}
 
 	var license License
 	if err := json.Unmarshal(data, &license); err != nil {
-		return nil, fmt.Errorf("failed to parse license file: %v", err)
+		return nil, fmt.Errorf("%w - failed to parse: %v", ErrCorrupted, err)
 	}
 
-	if license.PCId != currentPcId {
-		return nil, fmt.Errorf("license is not valid for this PC")
+	// Older formats do not bind the product in the header, so check the payload too
+	if license.ProductName != productName {
+		return &license, fmt.Errorf("%w - it belongs to product %q", ErrCorrupted, license.ProductName)
 	}
 
-	expectedSerial := m.crypto.GenerateSerial(currentPcId, license.ProductName, license.MaxDays)
-	if license.Serial != expectedSerial {
-		return nil, fmt.Errorf("license serial is invalid")
+	if err := m.checkMachine(&license, currentPcId); err != nil {
+		return &license, err
 	}
 
-	now := time.Now()
+	if err := m.verifySerial(&license, header); err != nil {
+		return &license, err
+	}
+
+	if m.crypto.IsAsymmetric() {
+		if err := m.verifyLicenseSignature(&license); err != nil {
+			return &license, err
+		}
+	}
+
+	return &license, nil
+}
+
+// readAndVerifyLicense reads, decrypts, and verifies the license of a product and updates usage tracking
+// as of now. It returns the license as read and as updated by this run; they are the same license
+// unless readOnly is set, in which case nothing is written. When the license could be decoded but
+// fails a check, it is returned as read along with the error.
+func (m *Manager) readAndVerifyLicense(productName, currentPcId string, readOnly bool, now time.Time) (*License, *License, error) {
+	// Concurrent validations must not overwrite each other's usage updates
+	if !readOnly {
+		if err := m.checkExists(productName); err != nil {
+			return nil, nil, err
+		}
+		unlock, err := m.lock(productName)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer unlock()
+	}
+
+	license, err := m.loadLicense(productName, currentPcId)
+	if err != nil {
+		return license, nil, err
+	}
+
+	// A clock set back could make an expired license look valid, so it is checked first
+	if err := m.checkClock(license, now); err != nil {
+		return license, nil, err
+	}
+
+	if err := m.checkState(license); err != nil {
+		return license, nil, err
+	}
+
+	if err := license.checkWindow(now); err != nil {
+		return license, nil, err
+	}
+
+	// A calendar window that has run out, grace included, is rejected before usage is recorded
+	if err := license.checkExpired(now); err != nil {
+		return license, nil, err
+	}
+
+	// A read-only validation records this run on a copy, so it rejects exactly what a real
+	// validation would while the stored license stays untouched
+	updated := license
+	if readOnly {
+		updated = license.clone()
+	}
+	if err := updated.advanceGeneration(); err != nil {
+		return license, nil, err
+	}
+	if err := updated.recordUsage(now); err != nil {
+		return license, nil, err
+	}
+
+	if err := updated.checkExpired(now); err != nil {
+		return license, nil, err
+	}
+
+	if readOnly {
+		return license, updated, nil
+	}
+
+	if err := m.saveLicense(updated); err != nil {
+		return license, nil, fmt.Errorf("failed to update license usage: %v", err)
+	}
+	m.recordHighWater(productName, now)
+
+	return updated, updated, nil
+}
+
+// recordUsage counts a run at now, adding today to the usage history on its first run of the day.
+// The clock must already have been checked against the last use, see checkClock.
+func (l *License) recordUsage(now time.Time) error {
 	nowRFC3339 := now.Format(time.RFC3339)
 	today := now.Format("2006-01-02")
 
-	if !license.IsActivated {
-		license.IsActivated = true
-		license.FirstRunDate = nowRFC3339
-		license.LastUsedDate = nowRFC3339
-		license.RunCount = 1
-		license.UsageHistory = []string{today}
+	if !l.IsActivated {
+		l.IsActivated = true
+		l.FirstRunDate = nowRFC3339
+		l.LastUsedDate = nowRFC3339
+		l.RunCount = 1
+		l.UsageHistory = []string{today}
 		// Initialize usage map for better performance
-		license.UsageMap = map[string]bool{today: true}
+		l.UsageMap = map[string]bool{today: true}
 	} else {
-		license.RunCount++
-
-		// Simple time rollback detection and same time check
-		if license.LastUsedDate != "" {
-			// Check if it's the same time (prevent multiple uses within same time)
-			if license.LastUsedDate == nowRFC3339 {
-				// Same exact time, just update and return
-				if err := m.saveLicense(&license, filename); err != nil {
-					return nil, fmt.Errorf("failed to update license usage: %v", err)
-				}
-				return &license, nil
-			}
+		l.RunCount++
 
-			// Basic time rollback check - if last used date is in the future compared to now
-			if license.LastUsedDate > nowRFC3339 {
-				return nil, fmt.Errorf("system date/time appears to have been rolled back - license validation failed")
-			}
+		// Check if it's the same time (prevent multiple uses within same time)
+		if l.LastUsedDate == nowRFC3339 {
+			// Same exact time, just count the run
+			return nil
 		}
 
 		// Initialize usage map if it doesn't exist (for backward compatibility)
-		if license.UsageMap == nil {
-			license.UsageMap = make(map[string]bool)
-			for _, date := range license.UsageHistory {
-				license.UsageMap[date] = true
+		if l.UsageMap == nil {
+			l.UsageMap = make(map[string]bool)
+			for _, date := range l.UsageHistory {
+				l.UsageMap[date] = true
 			}
 		}
 
 		// Use map for O(1) lookup, fallback to optimized array search
 		usedToday := false
-		if license.UsageMap != nil {
-			usedToday = license.UsageMap[today]
+		if l.UsageMap != nil {
+			usedToday = l.UsageMap[today]
 		} else {
 			// Performance optimization: check the last entry first since dates are added chronologically
-			if len(license.UsageHistory) > 0 && license.UsageHistory[len(license.UsageHistory)-1] == today {
+			if len(l.UsageHistory) > 0 && l.UsageHistory[len(l.UsageHistory)-1] == today {
 				usedToday = true
 			} else {
 				// Fallback: search through all entries (for backward compatibility or edge cases)
-				if slices.Contains(license.UsageHistory, today) {
+				if slices.Contains(l.UsageHistory, today) {
 					usedToday = true
 				}
 			}
 		}
 
 		if !usedToday {
-			license.LastUsedDate = nowRFC3339
-			license.UsageHistory = append(license.UsageHistory, today)
+			l.UsageHistory = append(l.UsageHistory, today)
 			// Update usage map
-			if license.UsageMap != nil {
-				license.UsageMap[today] = true
+			if l.UsageMap != nil {
+				l.UsageMap[today] = true
 			}
-		} else {
-			license.LastUsedDate = nowRFC3339
 		}
-	}
 
-	if !license.IsLifetime && len(license.UsageHistory) > license.MaxDays {
-		return nil, fmt.Errorf("license has expired - used %d days out of %d allowed", len(license.UsageHistory), license.MaxDays)
+		// The last use is a high-water mark: a clock behind it within the tolerated skew
+		// does not move it back
+		if lastUsed, ok := l.lastUsed(); !ok || now.After(lastUsed) {
+			l.LastUsedDate = nowRFC3339
+		}
 	}
 
-	if err := m.saveLicense(&license, filename); err != nil {
-		return nil, fmt.Errorf("failed to update license usage: %v", err)
-	}
+	return nil
+}
 
-	return &license, nil
+// clone returns a copy of the license that shares no usage state with it
+func (l *License) clone() *License {
+	c := *l
+	c.UsageHistory = slices.Clone(l.UsageHistory)
+	c.UsageMap = maps.Clone(l.UsageMap)
+	return &c
 }
@@ -1,12 +1,21 @@
 package license
 
 import (
+	"encoding/json"
+	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/AmrEsam0/license-manager/pkg/clock"
 	"github.com/AmrEsam0/license-manager/pkg/config"
+	"github.com/AmrEsam0/license-manager/pkg/crypto"
+	"github.com/AmrEsam0/license-manager/pkg/hardware"
 )
 
 // TestProductName is used across tests
@@ -26,6 +35,9 @@ func setupTestManager(t *testing.T) (*Manager, string) {
 	oldLicenseDir := os.Getenv("LICENSE_DIR")
 	os.Setenv("LICENSE_DIR", tempDir)
 
+	// Keep usage records in their own directory, outside the license directory
+	os.Setenv("LICENSE_STATE_DIR", t.TempDir())
+
 	// Set a fixed master key for testing
 	oldMasterKey := os.Getenv("LICENSE_MASTER_KEY")
 	os.Setenv("LICENSE_MASTER_KEY", "TestMasterKeyForLicenseTests12345678901234")
@@ -52,6 +64,7 @@ func cleanupTest(t *testing.T, tempDir string) {
 
 	// Restore environment variables
 	os.Unsetenv("LICENSE_DIR")
+	os.Unsetenv("LICENSE_STATE_DIR")
 	os.Unsetenv("LICENSE_MASTER_KEY")
 }
 
@@ -242,8 +255,9 @@ func TestGetProductInfo(t *testing.T) {
 	if info.RemainingDays != 29 {
 		t.Errorf("Expected remaining days 29, got %d", info.RemainingDays)
 	}
-	if info.RunCount != 2 {
-		t.Errorf("Expected run count 2, got %d", info.RunCount)
+	// GetInfo is read-only and does not count as a run
+	if info.RunCount != 1 {
+		t.Errorf("Expected run count 1, got %d", info.RunCount)
 	}
 	if !info.IsValid {
 		t.Errorf("Expected license to be valid")
@@ -340,6 +354,61 @@ func TestRevokeProductNotFound(t *testing.T) {
 	}
 }
 
+// TestMissingProductLeavesNoFiles tests that looking up a product without a license does not
+// create the license directory or a lock file
+func TestMissingProductLeavesNoFiles(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	licenseDir := filepath.Join(tempDir, "missing")
+	t.Setenv("LICENSE_DIR", licenseDir)
+
+	if result, _ := manager.Validate("Typo"); result.IsValid || !errors.Is(result.Err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %+v", result)
+	}
+	if err := manager.Revoke("Typo"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when revoking, got %v", err)
+	}
+	if _, err := os.Stat(licenseDir); !os.IsNotExist(err) {
+		t.Errorf("Expected the license directory not to be created, got %v", err)
+	}
+}
+
+// unreadableStore is a memory store whose reads fail
+type unreadableStore struct {
+	*MemoryStore
+}
+
+func (s unreadableStore) Get(string) ([]byte, error) {
+	return nil, errors.New("store unavailable")
+}
+
+// TestRevokeReadError tests that revoking does not overwrite a license the store cannot read
+func TestRevokeReadError(t *testing.T) {
+	_, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	store := unreadableStore{NewMemoryStore()}
+	store.Put(TestProductName, []byte("license"))
+	manager, err := NewManagerWithOptions(ManagerOptions{
+		MasterKey:     "TestMasterKeyForLicenseTests12345678901234",
+		Fingerprinter: &hardware.StaticFingerprinter{ID: "unreadable-store"},
+		Store:         store,
+		StateStore:    NewMemoryStore(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	err = manager.Revoke(TestProductName)
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "store unavailable") {
+		t.Errorf("Expected the read error to be reported, got %v", err)
+	}
+	if data, _ := store.MemoryStore.Get(TestProductName); string(data) != "license" {
+		t.Errorf("Expected the license to be left in place, got %q", data)
+	}
+}
+
 // TestMultipleProducts tests managing multiple product licenses
 func TestMultipleProducts(t *testing.T) {
 	manager, tempDir := setupTestManager(t)
@@ -396,9 +465,6 @@ func TestPCIDMismatch(t *testing.T) {
 	manager, tempDir := setupTestManager(t)
 	defer cleanupTest(t, tempDir)
 
-	// Create a mocked license with a different PC ID
-	cfg := config.LoadConfig()
-
 	// We'll use a fake PC ID for creating the license
 	fakePCID := "fake-pc-id-for-testing"
 
@@ -430,28 +496,19 @@ func TestPCIDMismatch(t *testing.T) {
 
 	// Validation should fail due to PC ID mismatch
 	// We need to manually validate since we're using a fake PC ID
-	licenseFile, _ := cfg.GetLicenseFilePathForProduct(TestProductName)
-	_, err = manager.readAndVerifyLicense(licenseFile, manager.PCID)
+	_, _, err = manager.readAndVerifyLicense(TestProductName, manager.PCID, false, time.Now())
 	if err == nil {
 		t.Errorf("Expected validation to fail due to PC ID mismatch, but it succeeded")
 	}
 }
 
-// MockPCIDGenerator creates a mock PC ID generator for testing
+// MockPCIDGenerator is a deterministic hardware.Fingerprinter for testing
 type MockPCIDGenerator struct {
-	pcid string
-}
-
-func (m *MockPCIDGenerator) Generate() (string, error) {
-	return m.pcid, nil
-}
-
-func (m *MockPCIDGenerator) IsSupported() bool {
-	return true
+	components []hardware.Component
 }
 
-func (m *MockPCIDGenerator) GetSupportedPlatforms() string {
-	return "mock"
+func (m *MockPCIDGenerator) Components() ([]hardware.Component, error) {
+	return m.components, nil
 }
 
 // TestNewManager tests creating a new manager
@@ -496,3 +553,1902 @@ func TestNewManagerWithKey(t *testing.T) {
 		t.Errorf("New manager has empty PC ID")
 	}
 }
+
+// TestSignedLicense tests issuing with a private key and validating with the public key only
+func TestSignedLicense(t *testing.T) {
+	_, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	publicKey, privateKey, err := crypto.GenerateSigningKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	masterKey := os.Getenv("LICENSE_MASTER_KEY")
+	issuer, err := NewManagerWithSigningKey(masterKey, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create issuing manager: %v", err)
+	}
+
+	created, err := issuer.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30})
+	if err != nil {
+		t.Fatalf("Failed to create signed license: %v", err)
+	}
+	if created.Signature == "" {
+		t.Fatalf("Expected signed license to carry a signature")
+	}
+
+	client, err := NewManagerWithPublicKey(masterKey, publicKey)
+	if err != nil {
+		t.Fatalf("Failed to create verifying manager: %v", err)
+	}
+
+	result, err := client.Validate(TestProductName)
+	if err != nil {
+		t.Fatalf("Unexpected error from Validate: %v", err)
+	}
+	if !result.IsValid {
+		t.Errorf("Expected signed license to be valid, got: %s", result.ErrorMessage)
+	}
+
+	// A verify-only manager must not be able to mint licenses
+	if _, err := client.Create(CreateLicenseRequest{ProductName: "Other", MaxDays: 30}); err == nil {
+		t.Errorf("Expected Create to fail without the signing key")
+	}
+}
+
+// TestUnsignedLicenseRejectedInAsymmetricMode tests that symmetric licenses are refused by a public-key manager
+func TestUnsignedLicenseRejectedInAsymmetricMode(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	publicKey, _, err := crypto.GenerateSigningKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	client, err := NewManagerWithPublicKey(os.Getenv("LICENSE_MASTER_KEY"), publicKey)
+	if err != nil {
+		t.Fatalf("Failed to create verifying manager: %v", err)
+	}
+
+	result, _ := client.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected unsigned license to be rejected in asymmetric mode")
+	}
+}
+
+// TestSerialCoversAllClaims tests that tampering with any issued field invalidates the serial
+func TestSerialCoversAllClaims(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	created, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30})
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if crypto.IsLegacySerial(created.Serial) {
+		t.Fatalf("Expected versioned serial, got %s", created.Serial)
+	}
+
+	// Flip a field the legacy serial did not cover
+	created.IsLifetime = true
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save tampered license: %v", err)
+	}
+
+	result, _ := manager.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected tampered license to be invalid")
+	}
+}
+
+// TestLegacySerialMigration tests that legacy serials are accepted and upgraded during migration
+func TestLegacySerialMigration(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	created, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30})
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	created.Serial, err = manager.crypto.GenerateSerial(created.PCId, created.ProductName, created.MaxDays)
+	if err != nil {
+		t.Fatalf("Failed to generate legacy serial: %v", err)
+	}
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save legacy license: %v", err)
+	}
+
+	manager.config.AllowLegacySerials = false
+	result, _ := manager.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected legacy serial to be rejected once migration window is closed")
+	}
+
+	manager.config.AllowLegacySerials = true
+	result, _ = manager.Validate(TestProductName)
+	if !result.IsValid {
+		t.Fatalf("Expected legacy serial to be accepted during migration, got: %s", result.ErrorMessage)
+	}
+	if crypto.IsLegacySerial(result.License.Serial) {
+		t.Errorf("Expected legacy serial to be upgraded on save")
+	}
+}
+
+// TestKeyRotation tests that licenses under a retired key validate and can be rekeyed
+func TestKeyRotation(t *testing.T) {
+	oldManager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	oldKey := os.Getenv("LICENSE_MASTER_KEY")
+	newKey := "RotatedMasterKeyForLicenseTests123456789"
+
+	for _, product := range []string{"Product1", "Product2"} {
+		if _, err := oldManager.Create(CreateLicenseRequest{ProductName: product, MaxDays: 30}); err != nil {
+			t.Fatalf("Failed to create license for %s: %v", product, err)
+		}
+	}
+	if _, err := oldManager.Validate("Product1"); err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+
+	// Without the retired key the new manager cannot read old files
+	newOnly, err := NewManagerWithKey(newKey)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if result, _ := newOnly.Validate("Product1"); result.IsValid {
+		t.Fatalf("Expected license under unknown key to be invalid")
+	}
+
+	rotated, err := NewManagerWithKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create manager with keyring: %v", err)
+	}
+
+	results, err := rotated.Rekey()
+	if err != nil {
+		t.Fatalf("Failed to rekey: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 rekey results, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("Failed to rekey %s: %v", result.File, result.Err)
+		}
+	}
+
+	// After rekeying the new key alone is enough and usage state is preserved
+	result, _ := newOnly.Validate("Product1")
+	if !result.IsValid {
+		t.Fatalf("Expected rekeyed license to be valid, got: %s", result.ErrorMessage)
+	}
+	if result.License.RunCount != 2 {
+		t.Errorf("Expected run count 2 after rekey, got %d", result.License.RunCount)
+	}
+}
+
+// TestKDFMigration tests that a license written with scrypt validates under the default KDF
+func TestKDFMigration(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	params, err := crypto.ParseKDFParams("scrypt:n=1024,r=8,p=1")
+	if err != nil {
+		t.Fatalf("Failed to parse KDF: %v", err)
+	}
+	if err := manager.SetKDF(params); err != nil {
+		t.Fatalf("Failed to set KDF: %v", err)
+	}
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	header, err := manager.Format(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+	if header.KDF != params {
+		t.Errorf("Expected header KDF %s, got %s", params, header.KDF)
+	}
+
+	reader, err := NewManager()
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	result, _ := reader.Validate(TestProductName)
+	if !result.IsValid {
+		t.Fatalf("Expected scrypt license to validate, got: %s", result.ErrorMessage)
+	}
+
+	header, _ = reader.Format(TestProductName)
+	if header.KDF != crypto.DefaultKDFParams() {
+		t.Errorf("Expected license to be rewritten with default KDF, got %s", header.KDF)
+	}
+}
+
+// TestRenamedLicenseFileRejected tests that a license file copied to another product name is refused
+func TestRenamedLicenseFileRejected(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: "ProductA", MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "ProductA.license"))
+	if err != nil {
+		t.Fatalf("Failed to read license file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "ProductB.license"), data, 0644); err != nil {
+		t.Fatalf("Failed to copy license file: %v", err)
+	}
+
+	result, _ := manager.Validate("ProductB")
+	if result.IsValid {
+		t.Errorf("Expected license copied to another product to be invalid")
+	}
+}
+
+// TestEntitlements tests feature flags, limits and metadata lookups
+func TestEntitlements(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	req := CreateLicenseRequest{
+		ProductName: TestProductName,
+		MaxDays:     30,
+		Features:    []string{"pro-reports", "export", "export"},
+		Limits:      map[string]int{"max_projects": 5},
+		Metadata:    map[string]string{"customer": "Acme"},
+	}
+	created, err := manager.Create(req)
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if len(created.Features) != 2 || created.Features[0] != "export" {
+		t.Errorf("Expected features to be sorted and de-duplicated, got %v", created.Features)
+	}
+
+	hasExport, err := manager.HasFeature(TestProductName, "export")
+	if err != nil || !hasExport {
+		t.Errorf("Expected export feature, got %v (err %v)", hasExport, err)
+	}
+	hasAdmin, _ := manager.HasFeature(TestProductName, "admin")
+	if hasAdmin {
+		t.Errorf("Expected admin feature to be missing")
+	}
+
+	limit, ok, err := manager.Limit(TestProductName, "max_projects")
+	if err != nil || !ok || limit != 5 {
+		t.Errorf("Expected max_projects limit 5, got %d, %v (err %v)", limit, ok, err)
+	}
+	if _, ok, _ := manager.Limit(TestProductName, "max_users"); ok {
+		t.Errorf("Expected max_users limit to be undefined")
+	}
+
+	// Entitlements are covered by the serial
+	created.Limits["max_projects"] = 500
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save tampered license: %v", err)
+	}
+	if _, _, err := manager.Limit(TestProductName, "max_projects"); err == nil {
+		t.Errorf("Expected tampered limit to fail verification")
+	}
+}
+
+// TestCalendarExpiry tests licenses that expire on a fixed date
+func TestCalendarExpiry(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	req := CreateLicenseRequest{
+		ProductName: TestProductName,
+		ExpiryMode:  ExpiryCalendar,
+		NotAfter:    time.Now().Add(72 * time.Hour),
+	}
+	created, err := manager.Create(req)
+	if err != nil {
+		t.Fatalf("Failed to create calendar license: %v", err)
+	}
+	if created.MaxDays != 0 || created.Mode() != ExpiryCalendar {
+		t.Errorf("Expected calendar license without usage days, got mode %s with %d days", created.Mode(), created.MaxDays)
+	}
+	if remaining := created.RemainingDays(time.Now()); remaining != 3 {
+		t.Errorf("Expected 3 remaining days, got %d", remaining)
+	}
+
+	result, err := manager.Validate(TestProductName)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Expected calendar license to be valid, got %+v (err %v)", result, err)
+	}
+
+	// Move the end date into the past
+	past := time.Now().Add(-time.Hour).UTC()
+	created.NotAfter = &past
+	if err := manager.authenticateLicense(created); err != nil {
+		t.Fatalf("Failed to authenticate license: %v", err)
+	}
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+
+	result, _ = manager.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected calendar license past its end date to be invalid")
+	}
+}
+
+// TestHybridExpiry tests that hybrid licenses report whichever limit is closer
+func TestHybridExpiry(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	req := CreateLicenseRequest{
+		ProductName: TestProductName,
+		MaxDays:     30,
+		ExpiryMode:  ExpiryHybrid,
+		NotAfter:    time.Now().Add(48 * time.Hour),
+	}
+	if _, err := manager.Create(req); err != nil {
+		t.Fatalf("Failed to create hybrid license: %v", err)
+	}
+
+	info, err := manager.GetInfo(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to get license info: %v", err)
+	}
+	if info.ExpiryMode != ExpiryHybrid || info.NotAfter == nil {
+		t.Errorf("Expected hybrid expiry with an end date, got %s", info.ExpiryMode)
+	}
+	if info.RemainingDays != 2 {
+		t.Errorf("Expected calendar limit of 2 remaining days, got %d", info.RemainingDays)
+	}
+}
+
+// TestNotBeforeDoesNotConsumeUsage tests that a license used before its start date is
+// rejected without recording a usage day
+func TestNotBeforeDoesNotConsumeUsage(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	req := CreateLicenseRequest{
+		ProductName: TestProductName,
+		MaxDays:     30,
+		NotBefore:   time.Now().Add(48 * time.Hour),
+	}
+	if _, err := manager.Create(req); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	result, _ := manager.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected license to be invalid before its start date")
+	}
+
+	lic, err := manager.View(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to view license: %v", err)
+	}
+	if len(lic.UsageHistory) != 0 || lic.RunCount != 0 {
+		t.Errorf("Expected no usage to be recorded, got %d days and %d runs", len(lic.UsageHistory), lic.RunCount)
+	}
+}
+
+// TestInvalidExpiryRequests tests that inconsistent expiry settings are rejected
+func TestInvalidExpiryRequests(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	future := time.Now().Add(24 * time.Hour)
+	requests := map[string]CreateLicenseRequest{
+		"calendar without end": {ProductName: TestProductName, ExpiryMode: ExpiryCalendar},
+		"hybrid without days":  {ProductName: TestProductName, ExpiryMode: ExpiryHybrid, NotAfter: future},
+		"end without mode":     {ProductName: TestProductName, MaxDays: 30, NotAfter: future},
+		"calendar lifetime":    {ProductName: TestProductName, IsLifetime: true, ExpiryMode: ExpiryCalendar, NotAfter: future},
+		"start after end":      {ProductName: TestProductName, ExpiryMode: ExpiryCalendar, NotBefore: future.Add(time.Hour), NotAfter: future},
+		"unknown mode":         {ProductName: TestProductName, MaxDays: 30, ExpiryMode: "weekly"},
+	}
+	for name, req := range requests {
+		if _, err := manager.Create(req); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+// TestGracePeriod tests that expired licenses keep validating during their grace days
+func TestGracePeriod(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	req := CreateLicenseRequest{
+		ProductName: TestProductName,
+		MaxDays:     1,
+		GraceDays:   2,
+	}
+	created, err := manager.Create(req)
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	// Use up the single allowed day before today
+	created.IsActivated = true
+	created.UsageHistory = []string{"2020-01-01"}
+	created.UsageMap = map[string]bool{"2020-01-01": true}
+	created.LastUsedDate = "2020-01-01T00:00:00Z"
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+
+	result, err := manager.Validate(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if !result.IsValid || result.Status != StatusGracePeriod {
+		t.Fatalf("Expected valid license in grace period, got %s (%s)", result.Status, result.ErrorMessage)
+	}
+	if result.GraceDaysRemaining != 1 {
+		t.Errorf("Expected 1 grace day remaining, got %d", result.GraceDaysRemaining)
+	}
+
+	// Exhaust the grace period
+	lic := result.License
+	lic.UsageHistory = append([]string{"2019-12-30", "2019-12-31"}, lic.UsageHistory...)
+	lic.UsageMap = nil
+	if err := manager.saveLicense(lic); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+
+	result, _ = manager.Validate(TestProductName)
+	if result.IsValid || result.Status != StatusExpired {
+		t.Errorf("Expected expired status after the grace period, got %s", result.Status)
+	}
+}
+
+// TestCalendarGracePeriod tests grace days after a calendar end date
+func TestCalendarGracePeriod(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	req := CreateLicenseRequest{
+		ProductName: TestProductName,
+		ExpiryMode:  ExpiryCalendar,
+		NotAfter:    time.Now().Add(time.Hour),
+		GraceDays:   3,
+	}
+	created, err := manager.Create(req)
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	past := time.Now().Add(-36 * time.Hour).UTC()
+	created.NotAfter = &past
+	if err := manager.authenticateLicense(created); err != nil {
+		t.Fatalf("Failed to authenticate license: %v", err)
+	}
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+
+	result, _ := manager.Validate(TestProductName)
+	if !result.IsValid || result.Status != StatusGracePeriod || result.GraceDaysRemaining != 1 {
+		t.Errorf("Expected grace period with 1 day remaining, got %s with %d (%s)", result.Status, result.GraceDaysRemaining, result.ErrorMessage)
+	}
+
+	// Grace days are covered by the serial
+	created.GraceDays = 30
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+	result, _ = manager.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected tampered grace days to fail verification")
+	}
+}
+
+// TestDefaultGraceDays tests the configured grace default and issuing licenses without grace
+func TestDefaultGraceDays(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+	manager.config.GraceDays = 5
+
+	cases := []struct {
+		req      CreateLicenseRequest
+		expected int
+	}{
+		{CreateLicenseRequest{}, 5},
+		{CreateLicenseRequest{GraceDays: 2}, 2},
+		{CreateLicenseRequest{NoGrace: true}, 0},
+	}
+	for _, c := range cases {
+		c.req.ProductName = TestProductName
+		c.req.MaxDays = 30
+		c.req.TargetPCID = manager.PCID
+		_, issued, err := manager.Issue(c.req)
+		if err != nil {
+			t.Fatalf("Failed to issue license: %v", err)
+		}
+		if issued.GraceDays != c.expected {
+			t.Errorf("Expected %d grace days for %+v, got %d", c.expected, c.req, issued.GraceDays)
+		}
+	}
+
+	if _, _, err := manager.Issue(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30, TargetPCID: manager.PCID, GraceDays: 2, NoGrace: true}); err == nil {
+		t.Errorf("Expected NoGrace with GraceDays to be rejected")
+	}
+}
+
+// TestExpiringSoonStatus tests the warning threshold
+func TestExpiringSoonStatus(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	manager.config.WarnDays = 7
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 5}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: "Other Product", MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	result, _ := manager.Validate(TestProductName)
+	if !result.IsValid || result.Status != StatusExpiringSoon || result.RemainingDays != 4 {
+		t.Errorf("Expected expiring soon with 4 days remaining, got %s with %d", result.Status, result.RemainingDays)
+	}
+
+	result, _ = manager.Validate("Other Product")
+	if result.Status != StatusValid {
+		t.Errorf("Expected valid status, got %s", result.Status)
+	}
+}
+
+// TestOfflineActivation tests issuing a license for another machine from its activation request
+func TestOfflineActivation(t *testing.T) {
+	customer, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+	customer.PCID = "0123456789abcdef0123456789abcdef"
+
+	vendor, err := NewManager()
+	if err != nil {
+		t.Fatalf("Failed to create vendor manager: %v", err)
+	}
+	vendor.PCID = "fedcba9876543210fedcba9876543210"
+
+	activationReq, err := customer.CreateActivationRequest(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to create activation request: %v", err)
+	}
+
+	// The request travels as a file
+	requestData, err := json.Marshal(activationReq)
+	if err != nil {
+		t.Fatalf("Failed to encode activation request: %v", err)
+	}
+	received, err := ParseActivationRequest(requestData)
+	if err != nil {
+		t.Fatalf("Failed to parse activation request: %v", err)
+	}
+
+	tampered := *received
+	tampered.PCId = "00000000000000000000000000000000"
+	if _, _, err := vendor.IssueActivation(&tampered, CreateLicenseRequest{MaxDays: 30}); err == nil {
+		t.Errorf("Expected tampered activation request to be rejected")
+	}
+
+	licenseData, issued, err := vendor.IssueActivation(received, CreateLicenseRequest{MaxDays: 30})
+	if err != nil {
+		t.Fatalf("Failed to issue activation: %v", err)
+	}
+	if issued.PCId != customer.PCID || issued.ProductName != TestProductName {
+		t.Errorf("Expected license for %s/%s, got %s/%s", customer.PCID, TestProductName, issued.PCId, issued.ProductName)
+	}
+
+	if _, err := vendor.Activate(licenseData); err == nil {
+		t.Errorf("Expected activation on another machine to fail")
+	}
+
+	if _, err := customer.Activate(licenseData); err != nil {
+		t.Fatalf("Failed to activate license: %v", err)
+	}
+	result, err := customer.Validate(TestProductName)
+	if err != nil || !result.IsValid {
+		t.Errorf("Expected activated license to be valid, got %+v (err %v)", result, err)
+	}
+
+	// The pending request is consumed, so the same response cannot be replayed
+	if _, err := customer.Activate(licenseData); err == nil {
+		t.Errorf("Expected replayed activation to be rejected")
+	}
+}
+
+// TestIssueForTargetPCID tests issuing a license for a PC ID sent by a customer
+func TestIssueForTargetPCID(t *testing.T) {
+	vendor, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	customerPCID := "0123456789ABCDEF0123456789ABCDEF"
+
+	for _, invalid := range []string{"", "not-a-pc-id", "0123456789abcdef", "0123456789abcdef0123456789abcdeg"} {
+		if _, err := hardware.ParsePCID(invalid); err == nil {
+			t.Errorf("Expected PC ID %q to be rejected", invalid)
+		}
+	}
+	if _, _, err := vendor.Issue(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30, TargetPCID: "not-a-pc-id"}); err == nil {
+		t.Errorf("Expected invalid target PC ID to be rejected")
+	}
+
+	req := CreateLicenseRequest{
+		ProductName: TestProductName,
+		MaxDays:     30,
+		TargetPCID:  customerPCID,
+	}
+	data, issued, err := vendor.Issue(req)
+	if err != nil {
+		t.Fatalf("Failed to issue license: %v", err)
+	}
+	if issued.PCId != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected normalized target PC ID, got %s", issued.PCId)
+	}
+
+	// Issue does not write to the vendor's license directory
+	licenseFile := filepath.Join(tempDir, TestProductName+".license")
+	if _, err := os.Stat(licenseFile); !os.IsNotExist(err) {
+		t.Errorf("Expected Issue not to write a license file")
+	}
+
+	customer, err := NewManager()
+	if err != nil {
+		t.Fatalf("Failed to create customer manager: %v", err)
+	}
+	customer.PCID = issued.PCId
+	if _, err := customer.Activate(data); err != nil {
+		t.Fatalf("Failed to install license: %v", err)
+	}
+	result, err := customer.Validate(TestProductName)
+	if err != nil || !result.IsValid {
+		t.Errorf("Expected installed license to be valid, got %+v (err %v)", result, err)
+	}
+
+	// Create with a target PC ID writes the license for that machine
+	req.ProductName = "Other Product"
+	created, err := vendor.Create(req)
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if created.PCId != issued.PCId {
+		t.Errorf("Expected created license for %s, got %s", issued.PCId, created.PCId)
+	}
+}
+
+// TestFuzzyHardwareMatch tests that a license survives a changed hardware component
+// when enough of the others still match
+func TestFuzzyHardwareMatch(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	manager.fingerprints = map[string]string{"machine": "m1", "cpu": "c1", "mac": "n1"}
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	originalPCID := manager.PCID
+
+	// Replace the network card
+	manager.PCID = "0123456789abcdef0123456789abcdef"
+	manager.fingerprints = map[string]string{"machine": "m1", "cpu": "c1", "mac": "n2"}
+
+	result, _ := manager.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected exact PC ID match to be required by default")
+	}
+
+	manager.config.HardwareMatchThreshold = 2
+	result, _ = manager.Validate(TestProductName)
+	if !result.IsValid {
+		t.Fatalf("Expected 2 of 3 matching components to be accepted: %s", result.ErrorMessage)
+	}
+	if len(result.DriftedComponents) != 1 || result.DriftedComponents[0] != "mac" {
+		t.Errorf("Expected mac to be reported as drifted, got %v", result.DriftedComponents)
+	}
+
+	// Replace the CPU as well
+	manager.fingerprints["cpu"] = "c2"
+	result, _ = manager.Validate(TestProductName)
+	if result.IsValid {
+		t.Errorf("Expected 1 of 3 matching components to be rejected")
+	}
+
+	// Back on the original machine nothing has drifted
+	manager.PCID = originalPCID
+	result, _ = manager.Validate(TestProductName)
+	if !result.IsValid || result.DriftedComponents != nil {
+		t.Errorf("Expected exact match without drift, got %v (%s)", result.DriftedComponents, result.ErrorMessage)
+	}
+}
+
+// TestNewManagerWithOptions tests managers with injected fingerprinters
+func TestNewManagerWithOptions(t *testing.T) {
+	_, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	newMachine := func(machineID string) *Manager {
+		t.Helper()
+		manager, err := NewManagerWithOptions(ManagerOptions{
+			MasterKey: "TestMasterKeyForLicenseTests12345678901234",
+			Fingerprinter: &MockPCIDGenerator{components: []hardware.Component{
+				{Name: "machine", Value: machineID},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create manager: %v", err)
+		}
+		return manager
+	}
+
+	first := newMachine("machine-a")
+	if again := newMachine("machine-a"); again.PCID != first.PCID {
+		t.Errorf("Expected the same fingerprint to give the same PC ID")
+	}
+	second := newMachine("machine-b")
+	if second.PCID == first.PCID {
+		t.Errorf("Expected different fingerprints to give different PC IDs")
+	}
+
+	if _, err := first.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if result, _ := first.Validate(TestProductName); !result.IsValid {
+		t.Errorf("Expected license to be valid on its machine: %s", result.ErrorMessage)
+	}
+	if result, _ := second.Validate(TestProductName); result.IsValid {
+		t.Errorf("Expected license to be invalid on another machine")
+	}
+
+	static, err := NewManagerWithOptions(ManagerOptions{
+		MasterKey:     "TestMasterKeyForLicenseTests12345678901234",
+		Fingerprinter: &hardware.StaticFingerprinter{ID: "ci-runner"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager with static fingerprinter: %v", err)
+	}
+	if static.PCID != hardware.HashComponents([]hardware.Component{{Name: "static", Value: "ci-runner"}}) {
+		t.Errorf("Expected PC ID derived from the static ID, got %s", static.PCID)
+	}
+}
+
+// TestLegacyPCIDAccepted tests that licenses bound to a PC ID from an earlier ID scheme
+// keep validating on the same machine
+func TestLegacyPCIDAccepted(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	legacyPCID := "0123456789abcdef0123456789abcdef"
+	currentPCID := manager.PCID
+
+	manager.PCID = legacyPCID
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	manager.PCID = currentPCID
+
+	if result, _ := manager.Validate(TestProductName); result.IsValid {
+		t.Errorf("Expected unknown PC ID to be rejected")
+	}
+
+	manager.legacyPCIDs = []string{legacyPCID}
+	result, _ := manager.Validate(TestProductName)
+	if !result.IsValid {
+		t.Errorf("Expected license for the legacy PC ID to be valid: %s", result.ErrorMessage)
+	}
+}
+
+// TestEnvironmentPolicy tests the warn, refuse and alternate policies inside a container
+func TestEnvironmentPolicy(t *testing.T) {
+	_, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	container := &hardware.Environment{Container: "docker", Evidence: []string{"/.dockerenv exists"}}
+	newManager := func(policy config.EnvironmentPolicy, env *hardware.Environment, fingerprinter hardware.Fingerprinter) (*Manager, error) {
+		cfg := config.DefaultConfig()
+		cfg.ContainerPolicy = policy
+		cfg.HostIDFile = filepath.Join(tempDir, "host-id")
+		return NewManagerWithOptions(ManagerOptions{
+			MasterKey:     "TestMasterKeyForLicenseTests12345678901234",
+			Fingerprinter: fingerprinter,
+			Config:        cfg,
+			Environment:   env,
+		})
+	}
+	static := &hardware.StaticFingerprinter{ID: "container-machine"}
+
+	// allow and warn both validate; warn adds a warning
+	allowed, err := newManager(config.PolicyAllow, container, static)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if _, err := allowed.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if result, _ := allowed.Validate(TestProductName); !result.IsValid || len(result.Warnings) != 0 {
+		t.Errorf("Expected a valid license without warnings, got %v: %s", result.Warnings, result.ErrorMessage)
+	}
+
+	warned, err := newManager(config.PolicyWarn, container, static)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if warned.EnvironmentPolicy() != config.PolicyWarn {
+		t.Errorf("Expected warn policy, got %s", warned.EnvironmentPolicy())
+	}
+	if result, _ := warned.Validate(TestProductName); !result.IsValid || len(result.Warnings) != 1 {
+		t.Errorf("Expected a valid license with one warning, got %v: %s", result.Warnings, result.ErrorMessage)
+	}
+
+	// the container policy does not apply outside a container
+	host, err := newManager(config.PolicyRefuse, &hardware.Environment{}, static)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if result, _ := host.Validate(TestProductName); !result.IsValid {
+		t.Errorf("Expected the container policy to be ignored on bare metal: %s", result.ErrorMessage)
+	}
+
+	refused, err := newManager(config.PolicyRefuse, container, static)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if result, _ := refused.Validate(TestProductName); result.IsValid {
+		t.Errorf("Expected the refuse policy to invalidate the license")
+	}
+	if _, err := refused.CreateActivationRequest("Other"); err == nil {
+		t.Errorf("Expected the refuse policy to prevent activation requests")
+	}
+
+	// alternate binds to the host ID file instead of the hardware
+	if _, err := newManager(config.PolicyAlternate, container, nil); err == nil {
+		t.Errorf("Expected an error when the host ID file is missing")
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "host-id"), []byte("host-1234\n"), 0644); err != nil {
+		t.Fatalf("Failed to write host ID file: %v", err)
+	}
+	alternate, err := newManager(config.PolicyAlternate, container, nil)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	expected := hardware.HashComponents([]hardware.Component{{Name: "host", Value: "host-1234"}})
+	if alternate.PCID != expected {
+		t.Errorf("Expected PC ID from the host ID file %s, got %s", expected, alternate.PCID)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.VMPolicy = config.PolicyAlternate
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected the alternate policy without a host ID file to be rejected")
+	}
+}
+
+// TestMemoryStore tests that licenses live entirely in a custom store
+func TestMemoryStore(t *testing.T) {
+	_, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	store := NewMemoryStore()
+	opts := ManagerOptions{
+		MasterKey:     "TestMasterKeyForLicenseTests12345678901234",
+		Fingerprinter: &hardware.StaticFingerprinter{ID: "memory-store"},
+		Store:         store,
+	}
+
+	// A custom store needs a state store or an explicit opt-out of replay detection
+	if _, err := NewManagerWithOptions(opts); err == nil {
+		t.Errorf("Expected a custom store without a state store to be rejected")
+	}
+	optOut := opts
+	optOut.DisableReplayDetection = true
+	if _, err := NewManagerWithOptions(optOut); err != nil {
+		t.Errorf("Failed to create manager without replay detection: %v", err)
+	}
+	optOut.StateStore = NewMemoryStore()
+	if _, err := NewManagerWithOptions(optOut); err == nil {
+		t.Errorf("Expected a state store with replay detection disabled to be rejected")
+	}
+
+	opts.StateStore = NewMemoryStore()
+	manager, err := NewManagerWithOptions(opts)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	for _, product := range []string{"Product B", "Product A"} {
+		if _, err := manager.Create(CreateLicenseRequest{ProductName: product, MaxDays: 30}); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+	}
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: "Product A", MaxDays: 30}); err == nil {
+		t.Errorf("Expected creating an existing license to fail")
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read license dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files in the license directory, got %d", len(entries))
+	}
+
+	products, err := store.List()
+	if err != nil || len(products) != 2 || products[0] != "Product A" {
+		t.Errorf("Expected sorted products [Product A Product B], got %v (err %v)", products, err)
+	}
+
+	result, _ := manager.Validate("Product A")
+	if !result.IsValid || result.License.RunCount != 1 {
+		t.Fatalf("Expected a valid license with one run, got %+v", result)
+	}
+	if _, err := manager.View("Product A"); err != nil {
+		t.Errorf("Failed to view license: %v", err)
+	}
+
+	results, err := manager.Rekey()
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 rekey results, got %d (err %v)", len(results), err)
+	}
+	for _, result := range results {
+		if result.Err != nil || result.File != "" {
+			t.Errorf("Unexpected rekey result for %s: file %q, err %v", result.Product, result.File, result.Err)
+		}
+	}
+
+	if err := manager.Revoke("Product A"); err != nil {
+		t.Fatalf("Failed to revoke license: %v", err)
+	}
+	if result, _ := manager.Validate("Product A"); result.IsValid {
+		t.Errorf("Expected revoked license to be invalid")
+	}
+
+	if err := store.Delete("Product B"); err != nil {
+		t.Fatalf("Failed to delete license: %v", err)
+	}
+	if result, _ := manager.Validate("Product B"); result.IsValid || result.ErrorMessage == "" {
+		t.Errorf("Expected deleted license to be invalid")
+	}
+	if err := store.Delete("Product B"); err == nil {
+		t.Errorf("Expected deleting a missing license to fail")
+	}
+}
+
+// TestMemoryStoreTouchesNoFiles tests that a manager with custom stores writes no files,
+// neither into LICENSE_DIR nor into the working directory
+func TestMemoryStoreTouchesNoFiles(t *testing.T) {
+	for _, licenseDir := range []bool{true, false} {
+		dir := t.TempDir()
+		t.Chdir(dir)
+		if licenseDir {
+			t.Setenv("LICENSE_DIR", dir)
+		} else {
+			t.Setenv("LICENSE_DIR", "")
+		}
+
+		manager, err := NewManagerWithOptions(ManagerOptions{
+			MasterKey:     "TestMasterKeyForLicenseTests12345678901234",
+			Fingerprinter: &hardware.StaticFingerprinter{ID: "memory-store"},
+			Store:         NewMemoryStore(),
+			StateStore:    NewMemoryStore(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create manager: %v", err)
+		}
+		if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if result, _ := manager.Validate(TestProductName); !result.IsValid {
+				t.Fatalf("Expected a valid license, got %+v", result)
+			}
+		}
+		if _, err := manager.List(); err != nil {
+			t.Fatalf("Failed to list licenses: %v", err)
+		}
+		if err := manager.Revoke(TestProductName); err != nil {
+			t.Fatalf("Failed to revoke license: %v", err)
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Failed to read directory: %v", err)
+		}
+		for _, entry := range entries {
+			t.Errorf("Expected no files with LICENSE_DIR set=%v, found %s", licenseDir, entry.Name())
+		}
+	}
+}
+
+// TestConcurrentValidate tests that concurrent validations do not lose usage updates
+func TestConcurrentValidate(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	const goroutines, runs = 16, 10
+	var wg sync.WaitGroup
+	errs := make(chan string, goroutines*runs)
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range runs {
+				if result, _ := manager.Validate(TestProductName); !result.IsValid {
+					errs <- result.ErrorMessage
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Errorf("Validation failed: %s", msg)
+	}
+
+	lic, err := manager.View(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to view license: %v", err)
+	}
+	if lic.RunCount != goroutines*runs {
+		t.Errorf("Expected run count %d, got %d", goroutines*runs, lic.RunCount)
+	}
+
+	// Atomic writes must not leave temporary files behind
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read license dir: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("Unexpected temporary file %s", entry.Name())
+		}
+	}
+}
+
+// TestConcurrentValidateProcesses tests that validations from several processes sharing
+// the license directory do not lose usage updates
+func TestConcurrentValidateProcesses(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	const processes, runs = 4, 25
+	var wg sync.WaitGroup
+	outputs := make([]string, processes)
+	for i := range processes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestValidateHelperProcess$")
+			cmd.Env = append(os.Environ(), "LICENSE_TEST_HELPER_RUNS="+strconv.Itoa(runs))
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				outputs[i] = string(out)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, out := range outputs {
+		if out != "" {
+			t.Errorf("Helper process failed: %s", out)
+		}
+	}
+
+	lic, err := manager.View(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to view license: %v", err)
+	}
+	if lic.RunCount != processes*runs {
+		t.Errorf("Expected run count %d, got %d", processes*runs, lic.RunCount)
+	}
+}
+
+// TestValidateHelperProcess validates repeatedly when started by TestConcurrentValidateProcesses
+func TestValidateHelperProcess(t *testing.T) {
+	runs, err := strconv.Atoi(os.Getenv("LICENSE_TEST_HELPER_RUNS"))
+	if err != nil {
+		t.Skip("helper process for TestConcurrentValidateProcesses")
+	}
+
+	manager, err := NewManager()
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	for range runs {
+		if result, _ := manager.Validate(TestProductName); !result.IsValid {
+			t.Fatalf("Validation failed: %s", result.ErrorMessage)
+		}
+	}
+}
+
+// TestList tests the inventory of valid, foreign, expired and corrupted licenses
+func TestList(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	if summaries, err := manager.List(); err != nil || len(summaries) != 0 {
+		t.Fatalf("Expected an empty list, got %v (err %v)", summaries, err)
+	}
+
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: "Valid", MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	manager.Validate("Valid")
+
+	expired, err := manager.Create(CreateLicenseRequest{ProductName: "Expired", ExpiryMode: ExpiryCalendar, NotAfter: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	past := time.Now().Add(-time.Hour).UTC()
+	expired.NotAfter = &past
+	if err := manager.authenticateLicense(expired); err != nil {
+		t.Fatalf("Failed to authenticate license: %v", err)
+	}
+	if err := manager.saveLicense(expired); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+
+	foreign, _, err := manager.Issue(CreateLicenseRequest{ProductName: "Foreign", IsLifetime: true, TargetPCID: "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("Failed to issue license: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "Foreign.license"), foreign, 0644); err != nil {
+		t.Fatalf("Failed to write license: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tempDir, "Corrupted.license"), []byte("not a license"), 0644); err != nil {
+		t.Fatalf("Failed to write license: %v", err)
+	}
+
+	summaries, err := manager.List()
+	if err != nil {
+		t.Fatalf("Failed to list licenses: %v", err)
+	}
+	if len(summaries) != 4 {
+		t.Fatalf("Expected 4 licenses, got %d", len(summaries))
+	}
+	byProduct := make(map[string]LicenseSummary)
+	for _, summary := range summaries {
+		byProduct[summary.ProductName] = summary
+	}
+
+	if s := byProduct["Valid"]; s.Status != StatusValid || !s.IsActivated || !s.ValidForThisPC || s.RemainingDays != 29 || s.RunCount != 1 {
+		t.Errorf("Unexpected summary for valid license: %+v", s)
+	}
+	if s := byProduct["Expired"]; s.Status != StatusExpired || !s.ValidForThisPC || s.Error == "" {
+		t.Errorf("Unexpected summary for expired license: %+v", s)
+	}
+	if s := byProduct["Foreign"]; s.Status != StatusInvalid || s.ValidForThisPC || !s.IsLifetime {
+		t.Errorf("Unexpected summary for foreign license: %+v", s)
+	}
+	if s := byProduct["Corrupted"]; s.Status != StatusInvalid || s.Error == "" {
+		t.Errorf("Unexpected summary for corrupted license: %+v", s)
+	}
+
+	// Listing does not count as a run
+	if lic, _ := manager.View("Valid"); lic == nil || lic.RunCount != 1 {
+		t.Errorf("Expected listing to leave usage tracking untouched")
+	}
+}
+
+// TestReadOnlyChecksAgree tests that List and entitlement lookups reject a license on the day
+// a validation would use up more days than it has
+func TestReadOnlyChecksAgree(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	created, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 2, Features: []string{"export"}})
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	created.IsActivated = true
+	created.RunCount = 2
+	created.FirstRunDate = yesterday.AddDate(0, 0, -1).Format(time.RFC3339)
+	created.LastUsedDate = yesterday.Format(time.RFC3339)
+	created.UsageHistory = []string{yesterday.AddDate(0, 0, -1).Format("2006-01-02"), yesterday.Format("2006-01-02")}
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+
+	summaries, err := manager.List()
+	if err != nil || len(summaries) != 1 || summaries[0].Status != StatusExpired {
+		t.Errorf("Expected the license to be listed as expired, got %+v (err %v)", summaries, err)
+	}
+	if ok, err := manager.HasFeature(TestProductName, "export"); ok || !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected no features from a license a run would expire, got %v (err %v)", ok, err)
+	}
+	if result, _ := manager.Validate(TestProductName); result.IsValid || result.Status != StatusExpired {
+		t.Errorf("Expected validation to report the license as expired, got %+v", result)
+	}
+}
+
+// writeBaselineLicense writes a license the way the first release did: a headerless file named
+// after the product, with a legacy serial and no usage record. Used licenses ran yesterday.
+func writeBaselineLicense(t *testing.T, manager *Manager, dir, productName string, used bool) {
+	t.Helper()
+	serial, err := manager.crypto.GenerateSerial(manager.PCID, productName, 30)
+	if err != nil {
+		t.Fatalf("Failed to generate legacy serial: %v", err)
+	}
+	lic := License{
+		Serial:       serial,
+		PCId:         manager.PCID,
+		ProductName:  productName,
+		CreatedAt:    time.Now().AddDate(0, 0, -2),
+		MaxDays:      30,
+		UsageHistory: []string{},
+		UsageMap:     map[string]bool{},
+	}
+	if used {
+		yesterday := time.Now().AddDate(0, 0, -1)
+		lic.IsActivated = true
+		lic.RunCount = 1
+		lic.FirstRunDate = yesterday.Format(time.RFC3339)
+		lic.LastUsedDate = yesterday.Format(time.RFC3339)
+		lic.UsageHistory = []string{yesterday.Format("2006-01-02")}
+		lic.UsageMap = map[string]bool{yesterday.Format("2006-01-02"): true}
+	}
+	data, err := json.MarshalIndent(lic, "", "  ")
+	if err != nil {
+		t.Fatalf("Failed to marshal license: %v", err)
+	}
+	encrypted, err := manager.crypto.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Failed to encrypt license: %v", err)
+	}
+	file := filepath.Join(dir, strings.ReplaceAll(productName, " ", "_")+".license")
+	if err := os.WriteFile(file, encrypted, 0644); err != nil {
+		t.Fatalf("Failed to write license: %v", err)
+	}
+}
+
+// TestListBaselineLicense tests that headerless licenses are listed under the product in their
+// payload rather than their file name
+func TestListBaselineLicense(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	writeBaselineLicense(t, manager, tempDir, "My Product", true)
+
+	summaries, err := manager.List()
+	if err != nil {
+		t.Fatalf("Failed to list licenses: %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].ProductName != "My Product" || summaries[0].Status != StatusValid {
+		t.Fatalf("Expected a valid license for My Product, got %+v", summaries)
+	}
+
+	// A file renamed to another product keeps its file name and is reported as invalid
+	if err := os.Rename(filepath.Join(tempDir, "My_Product.license"), filepath.Join(tempDir, "Other.license")); err != nil {
+		t.Fatalf("Failed to rename license: %v", err)
+	}
+	summaries, _ = manager.List()
+	if len(summaries) != 1 || summaries[0].ProductName != "Other" || summaries[0].Status != StatusInvalid {
+		t.Errorf("Expected the renamed license to be invalid under its file name, got %+v", summaries)
+	}
+}
+
+// TestReadOnlyValidate tests that read-only validation checks everything but writes nothing
+func TestReadOnlyValidate(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	readOnly := ValidateOptions{ReadOnly: true}
+	if result, _ := manager.ValidateWithOptions(TestProductName, readOnly); result.IsValid {
+		t.Errorf("Expected a missing license to be invalid")
+	}
+
+	created, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 2})
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	licenseFile := filepath.Join(tempDir, TestProductName+".license")
+	before, err := os.ReadFile(licenseFile)
+	if err != nil {
+		t.Fatalf("Failed to read license file: %v", err)
+	}
+
+	// The status is what this run would leave: today counts as a used day
+	result, _ := manager.ValidateWithOptions(TestProductName, readOnly)
+	if !result.IsValid || result.RemainingDays != 1 {
+		t.Fatalf("Expected read-only validation to succeed with 1 day left after today, got %+v", result)
+	}
+	if result.License.IsActivated || result.License.RunCount != 0 {
+		t.Errorf("Expected the stored, unactivated license, got run count %d", result.License.RunCount)
+	}
+	after, _ := os.ReadFile(licenseFile)
+	if string(before) != string(after) {
+		t.Errorf("Expected read-only validation to leave the license file untouched")
+	}
+
+	// One day used before today: today is the last day, for a dry run as for a real one
+	yesterday := time.Now().AddDate(0, 0, -1)
+	created.IsActivated = true
+	created.RunCount = 1
+	created.FirstRunDate = yesterday.Format(time.RFC3339)
+	created.LastUsedDate = yesterday.Format(time.RFC3339)
+	created.UsageHistory = []string{yesterday.Format("2006-01-02")}
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+	dryRun, _ := manager.ValidateWithOptions(TestProductName, readOnly)
+	realRun, _ := manager.Validate(TestProductName)
+	if !dryRun.IsValid || dryRun.Status != realRun.Status || dryRun.RemainingDays != 0 || realRun.RemainingDays != 0 {
+		t.Errorf("Expected the dry run to match the real run on the last day, got %s with %d and %s with %d",
+			dryRun.Status, dryRun.RemainingDays, realRun.Status, realRun.RemainingDays)
+	}
+	if dryRun.License.RunCount != 1 {
+		t.Errorf("Expected the dry run to return the stored license, got run count %d", dryRun.License.RunCount)
+	}
+
+	// Both days used before today: a real run today would exceed the limit
+	created.IsActivated = true
+	created.RunCount = 2
+	created.FirstRunDate = yesterday.AddDate(0, 0, -1).Format(time.RFC3339)
+	created.LastUsedDate = yesterday.Format(time.RFC3339)
+	created.UsageHistory = []string{yesterday.AddDate(0, 0, -1).Format("2006-01-02"), yesterday.Format("2006-01-02")}
+	created.UsageMap = nil
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+	before, _ = os.ReadFile(licenseFile)
+
+	result, _ = manager.ValidateWithOptions(TestProductName, readOnly)
+	if result.IsValid || result.Status != StatusExpired {
+		t.Errorf("Expected read-only validation to report the license as expired, got %+v", result)
+	}
+	after, _ = os.ReadFile(licenseFile)
+	if string(before) != string(after) {
+		t.Errorf("Expected read-only validation to leave the license file untouched")
+	}
+	if lic, err := manager.View(TestProductName); err != nil || lic.RunCount != 2 {
+		t.Errorf("Expected run count to stay 2")
+	}
+}
+
+// TestTypedErrors tests that every validation failure is inspectable with errors.Is and a reason
+func TestTypedErrors(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	expectFailure := func(product string, sentinel error, reason Reason) {
+		t.Helper()
+		result, err := manager.Validate(product)
+		if err != nil {
+			t.Fatalf("Validate returned an error: %v", err)
+		}
+		if result.IsValid {
+			t.Fatalf("Expected %s to be invalid", product)
+		}
+		if !errors.Is(result.Err, sentinel) {
+			t.Errorf("Expected %s to fail with %v, got %v", product, sentinel, result.Err)
+		}
+		if result.Reason != reason {
+			t.Errorf("Expected reason %s for %s, got %s", reason, product, result.Reason)
+		}
+		if result.ErrorMessage != result.Err.Error() {
+			t.Errorf("Expected ErrorMessage to match Err, got %q", result.ErrorMessage)
+		}
+	}
+	writeLicense := func(product string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(tempDir, product+".license"), data, 0644); err != nil {
+			t.Fatalf("Failed to write license: %v", err)
+		}
+	}
+	create := func(product string) *License {
+		t.Helper()
+		lic, err := manager.Create(CreateLicenseRequest{ProductName: product, MaxDays: 30})
+		if err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+		return lic
+	}
+
+	expectFailure("Missing", ErrNotFound, ReasonNotFound)
+
+	writeLicense("Corrupted", []byte("not a license"))
+	expectFailure("Corrupted", ErrCorrupted, ReasonCorrupted)
+
+	foreign, _, err := manager.Issue(CreateLicenseRequest{ProductName: "Foreign", MaxDays: 30, TargetPCID: "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("Failed to issue license: %v", err)
+	}
+	writeLicense("Foreign", foreign)
+	expectFailure("Foreign", ErrWrongMachine, ReasonWrongMachine)
+
+	tampered := create("Tampered")
+	tampered.MaxDays = 3000
+	if err := manager.saveLicense(tampered); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+	expectFailure("Tampered", ErrSerialMismatch, ReasonSerialMismatch)
+
+	expired := create("Expired")
+	for i := range 31 {
+		expired.UsageHistory = append(expired.UsageHistory, time.Now().AddDate(0, 0, -40+i).Format("2006-01-02"))
+	}
+	expired.IsActivated = true
+	if err := manager.saveLicense(expired); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+	expectFailure("Expired", ErrExpired, ReasonExpired)
+
+	rolledBack := create("RolledBack")
+	rolledBack.IsActivated = true
+	rolledBack.LastUsedDate = time.Now().Add(48 * time.Hour).Format(time.RFC3339)
+	if err := manager.saveLicense(rolledBack); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+	expectFailure("RolledBack", ErrClockRollback, ReasonClockRollback)
+
+	create("Revoked")
+	if err := manager.Revoke("Revoked"); err != nil {
+		t.Fatalf("Failed to revoke license: %v", err)
+	}
+	expectFailure("Revoked", ErrRevoked, ReasonRevoked)
+	if _, err := manager.View("Revoked"); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expected View to report the revocation, got %v", err)
+	}
+	if _, err := manager.HasFeature("Revoked", "export"); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expected entitlement lookups to report the revocation, got %v", err)
+	}
+	if _, err := manager.GetInfo("Missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected GetInfo to report a missing license, got %v", err)
+	}
+
+	// Revocations survive rekeying
+	results, err := manager.Rekey()
+	if err != nil {
+		t.Fatalf("Failed to rekey: %v", err)
+	}
+	for _, result := range results {
+		if result.Product == "Revoked" && result.Err != nil {
+			t.Errorf("Failed to rekey revoked license: %v", result.Err)
+		}
+	}
+	expectFailure("Revoked", ErrRevoked, ReasonRevoked)
+
+	if result, _ := manager.Validate("Tampered"); result.Reason != ReasonSerialMismatch {
+		t.Errorf("Expected reason to be stable across validations, got %s", result.Reason)
+	}
+	if ReasonOf(nil) != ReasonNone {
+		t.Errorf("Expected no reason for a nil error")
+	}
+}
+
+// TestManagerRemainingDays tests that remaining days of a viewed license follow the manager's clock
+func TestManagerRemainingDays(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	start := time.Now()
+	fake := clock.NewFake(start)
+	manager.SetClock(fake)
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, ExpiryMode: ExpiryCalendar, NotAfter: start.Add(72 * time.Hour)}); err != nil {
+		t.Fatalf("Failed to create calendar license: %v", err)
+	}
+
+	fake.Advance(48 * time.Hour)
+	viewed, err := manager.View(TestProductName)
+	if err != nil {
+		t.Fatalf("Failed to view license: %v", err)
+	}
+	if remaining := manager.RemainingDays(viewed); remaining != 1 {
+		t.Errorf("Expected 1 remaining day on the manager's clock, got %d", remaining)
+	}
+}
+
+// TestFakeClockAcrossDays tests usage expiry and clock rollback by moving a fake clock
+func TestFakeClockAcrossDays(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	// Starts now: the clock must not be behind the system files
+	fake := clock.NewFake(time.Now())
+	manager.SetClock(fake)
+
+	created, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 3})
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if !created.CreatedAt.Equal(fake.Now()) {
+		t.Errorf("Expected the license to be created at the clock's time, got %v", created.CreatedAt)
+	}
+
+	for day := 1; day <= 3; day++ {
+		result, _ := manager.Validate(TestProductName)
+		if !result.IsValid {
+			t.Fatalf("Expected day %d to be valid, got %s", day, result.ErrorMessage)
+		}
+		if result.RemainingDays != 3-day {
+			t.Errorf("Expected %d remaining days on day %d, got %d", 3-day, day, result.RemainingDays)
+		}
+		fake.Advance(24 * time.Hour)
+	}
+
+	result, _ := manager.Validate(TestProductName)
+	if result.IsValid || !errors.Is(result.Err, ErrExpired) {
+		t.Errorf("Expected the license to expire on day 4, got %+v", result)
+	}
+
+	// Setting the clock back before the last use is a rollback
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: "Rollback", MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if result, _ := manager.Validate("Rollback"); !result.IsValid {
+		t.Fatalf("Expected license to be valid, got %s", result.ErrorMessage)
+	}
+	fake.Advance(-48 * time.Hour)
+	result, _ = manager.Validate("Rollback")
+	if result.IsValid || !errors.Is(result.Err, ErrClockRollback) {
+		t.Errorf("Expected a clock rollback, got %+v", result)
+	}
+}
+
+// TestClockRollbackAnchors tests rollback detection against the license, its usage record and
+// system file times, across timezone offsets and within the tolerated skew
+func TestClockRollbackAnchors(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	start := time.Now()
+	fake := clock.NewFake(start)
+	manager.SetClock(fake)
+	manager.config.ClockSkew = 5 * time.Minute
+
+	expectRollback := func(context string) {
+		t.Helper()
+		result, _ := manager.Validate(TestProductName)
+		if result.IsValid || !errors.Is(result.Err, ErrClockRollback) || result.Status != StatusClockRollback {
+			t.Errorf("Expected a clock rollback %s, got %+v", context, result)
+		}
+	}
+
+	created, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30})
+	if err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	// A last use recorded with a later-sorting offset is still in the past
+	created.IsActivated = true
+	created.RunCount = 1
+	created.LastUsedDate = start.Add(-time.Hour).In(time.FixedZone("UTC+14", 14*3600)).Format(time.RFC3339)
+	if err := manager.saveLicense(created); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+	if result, _ := manager.Validate(TestProductName); !result.IsValid {
+		t.Fatalf("Expected a last use in another timezone to be in the past, got %s", result.ErrorMessage)
+	}
+
+	// Within the tolerated skew, and the last use does not move back
+	fake.Advance(-time.Minute)
+	result, _ := manager.Validate(TestProductName)
+	if !result.IsValid {
+		t.Fatalf("Expected a clock one minute behind to be tolerated, got %s", result.ErrorMessage)
+	}
+	if result.License.LastUsedDate != start.Format(time.RFC3339) {
+		t.Errorf("Expected the last use to stay at %s, got %s", start.Format(time.RFC3339), result.License.LastUsedDate)
+	}
+	fake.Set(start.Add(-time.Hour))
+	expectRollback("behind the last use")
+
+	// Restoring an older license file is caught by the usage record
+	licenseFile := filepath.Join(tempDir, TestProductName+".license")
+	backup, err := os.ReadFile(licenseFile)
+	if err != nil {
+		t.Fatalf("Failed to read license file: %v", err)
+	}
+	fake.Set(start.AddDate(0, 0, 10))
+	if result, _ := manager.Validate(TestProductName); !result.IsValid {
+		t.Fatalf("Expected license to be valid, got %s", result.ErrorMessage)
+	}
+	if err := os.WriteFile(licenseFile, backup, 0644); err != nil {
+		t.Fatalf("Failed to restore license file: %v", err)
+	}
+	fake.Set(start.AddDate(0, 0, 1))
+	expectRollback("with a restored license file")
+
+	// So is deleting the license and installing a fresh one
+	if err := os.Remove(licenseFile); err != nil {
+		t.Fatalf("Failed to delete license file: %v", err)
+	}
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	expectRollback("with a new license file")
+
+	// A system file modified after the clock's time
+	systemFile := filepath.Join(tempDir, "wtmp")
+	if err := os.WriteFile(systemFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to write system file: %v", err)
+	}
+	future := start.AddDate(0, 0, 20)
+	if err := os.Chtimes(systemFile, future, future); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+	other, err := NewManagerWithOptions(ManagerOptions{
+		MasterKey:   "TestMasterKeyForLicenseTests12345678901234",
+		Clock:       clock.NewFake(start.AddDate(0, 0, 15)),
+		TimeAnchors: []TimeAnchor{&SystemFileAnchor{Paths: []string{systemFile}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if _, err := other.Create(CreateLicenseRequest{ProductName: "Other", MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	result, _ = other.Validate("Other")
+	if result.IsValid || result.Reason != ReasonClockRollback || !strings.Contains(result.ErrorMessage, "system file times") {
+		t.Errorf("Expected a rollback behind the system file times, got %+v", result)
+	}
+}
+
+// TestReplayDetection tests that restoring an older copy of a license file is detected, with or
+// without its usage record, and that interrupted saves are not mistaken for restored copies
+func TestReplayDetection(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	fake := clock.NewFake(time.Now())
+	manager.SetClock(fake)
+
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	licenseFile := filepath.Join(tempDir, TestProductName+".license")
+	stateFile := filepath.Join(os.Getenv("LICENSE_STATE_DIR"), TestProductName+".state")
+	readFile := func(path string) []byte {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		return data
+	}
+	writeFile := func(path string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	validateDays := func(days int) *ValidationResult {
+		t.Helper()
+		var result *ValidationResult
+		for range days {
+			fake.Advance(24 * time.Hour)
+			result, _ = manager.Validate(TestProductName)
+			if !result.IsValid {
+				t.Fatalf("Expected license to be valid, got %s", result.ErrorMessage)
+			}
+		}
+		return result
+	}
+
+	if result := validateDays(1); result.License.Generation != 1 {
+		t.Errorf("Expected generation 1 after the first run, got %d", result.License.Generation)
+	}
+	backup := readFile(licenseFile)
+	validateDays(3)
+	latest := readFile(licenseFile)
+
+	// A day-one copy restored later
+	writeFile(licenseFile, backup)
+	fake.Advance(24 * time.Hour)
+	result, _ := manager.Validate(TestProductName)
+	if result.IsValid || !errors.Is(result.Err, ErrReplayed) || result.Reason != ReasonReplayed {
+		t.Errorf("Expected a restored license to be reported as replayed, got %+v", result)
+	}
+	if _, err := manager.HasFeature(TestProductName, "export"); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected entitlement lookups to report the replay, got %v", err)
+	}
+
+	// Deleting the usage record as well does not help
+	state := readFile(stateFile)
+	if err := os.Remove(stateFile); err != nil {
+		t.Fatalf("Failed to delete state file: %v", err)
+	}
+	if result, _ := manager.Validate(TestProductName); !errors.Is(result.Err, ErrReplayed) {
+		t.Errorf("Expected a missing usage record to be reported as replayed, got %+v", result)
+	}
+	writeFile(stateFile, state)
+
+	// Nor does activating the copy
+	if _, err := manager.Activate(backup); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected activating a used license to fail, got %v", err)
+	}
+
+	// The latest copy is accepted, as is a save interrupted before the usage record was written
+	writeFile(licenseFile, latest)
+	state = readFile(stateFile)
+	validateDays(1)
+	writeFile(stateFile, state)
+	if result := validateDays(1); result.License.Generation != 6 {
+		t.Errorf("Expected generation 6 after an interrupted save, got %d", result.License.Generation)
+	}
+
+	// Restoring the last copy does not undo a revocation
+	current := readFile(licenseFile)
+	if err := manager.Revoke(TestProductName); err != nil {
+		t.Fatalf("Failed to revoke license: %v", err)
+	}
+	writeFile(licenseFile, current)
+	if result, _ := manager.Validate(TestProductName); !errors.Is(result.Err, ErrReplayed) {
+		t.Errorf("Expected a license restored after revocation to be replayed, got %+v", result)
+	}
+}
+
+// TestBaselineUnusedLicense tests that an unused license from the first release, which has no
+// usage record, validates after upgrading and is tracked from then on
+func TestBaselineUnusedLicense(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	writeBaselineLicense(t, manager, tempDir, TestProductName, false)
+	licenseFile := filepath.Join(tempDir, TestProductName+".license")
+	pristine, err := os.ReadFile(licenseFile)
+	if err != nil {
+		t.Fatalf("Failed to read license: %v", err)
+	}
+
+	result, _ := manager.Validate(TestProductName)
+	if !result.IsValid || result.License.RunCount != 1 {
+		t.Fatalf("Expected the baseline license to validate, got %s", result.ErrorMessage)
+	}
+
+	// Its first save created the usage record, so the unused copy is now a replay
+	if err := os.WriteFile(licenseFile, pristine, 0644); err != nil {
+		t.Fatalf("Failed to restore license: %v", err)
+	}
+	if result, _ := manager.Validate(TestProductName); result.IsValid || !errors.Is(result.Err, ErrReplayed) {
+		t.Errorf("Expected the restored baseline copy to be a replay, got %+v", result)
+	}
+}
+
+// TestWipeLicenseDir tests that deleting everything in the license directory, or restoring a
+// backup of all of it, does not let a used license be activated again
+func TestWipeLicenseDir(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	data, _, err := manager.Issue(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30, TargetPCID: manager.PCID})
+	if err != nil {
+		t.Fatalf("Failed to issue license: %v", err)
+	}
+	if _, err := manager.Activate(data); err != nil {
+		t.Fatalf("Failed to activate license: %v", err)
+	}
+	backup, err := os.ReadFile(filepath.Join(tempDir, TestProductName+".license"))
+	if err != nil {
+		t.Fatalf("Failed to read license file: %v", err)
+	}
+	if result, _ := manager.Validate(TestProductName); !result.IsValid {
+		t.Fatalf("Expected license to be valid, got %s", result.ErrorMessage)
+	}
+
+	wipe := func() {
+		t.Helper()
+		entries, err := os.ReadDir(tempDir)
+		if err != nil {
+			t.Fatalf("Failed to read license directory: %v", err)
+		}
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(tempDir, entry.Name())); err != nil {
+				t.Fatalf("Failed to delete %s: %v", entry.Name(), err)
+			}
+		}
+	}
+
+	wipe()
+	if _, err := manager.Activate(data); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected activating again after wiping the license directory to fail, got %v", err)
+	}
+
+	wipe()
+	if err := os.WriteFile(filepath.Join(tempDir, TestProductName+".license"), backup, 0644); err != nil {
+		t.Fatalf("Failed to restore license file: %v", err)
+	}
+	if result, _ := manager.Validate(TestProductName); !errors.Is(result.Err, ErrReplayed) {
+		t.Errorf("Expected a restored backup of the license directory to be replayed, got %+v", result)
+	}
+}
+
+// TestReplayOfUnusedCopy tests that a copy saved right after installation cannot be restored or
+// activated again once the license has been used or revoked, while renewals still install
+func TestReplayOfUnusedCopy(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer cleanupTest(t, tempDir)
+
+	fake := clock.NewFake(time.Now())
+	manager.SetClock(fake)
+
+	if _, err := manager.Create(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 3}); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	licenseFile := filepath.Join(tempDir, TestProductName+".license")
+	stateFile := filepath.Join(os.Getenv("LICENSE_STATE_DIR"), TestProductName+".state")
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatalf("Expected installing a license to record its usage state: %v", err)
+	}
+	unused, err := os.ReadFile(licenseFile)
+	if err != nil {
+		t.Fatalf("Failed to read license file: %v", err)
+	}
+
+	for range 4 {
+		manager.Validate(TestProductName)
+		fake.Advance(24 * time.Hour)
+	}
+	if result, _ := manager.Validate(TestProductName); !errors.Is(result.Err, ErrExpired) {
+		t.Fatalf("Expected the license to have expired, got %+v", result)
+	}
+
+	expectReplayed := func(context string) {
+		t.Helper()
+		if err := os.WriteFile(licenseFile, unused, 0644); err != nil {
+			t.Fatalf("Failed to restore license file: %v", err)
+		}
+		if result, _ := manager.Validate(TestProductName); !errors.Is(result.Err, ErrReplayed) {
+			t.Errorf("Expected the unused copy to be replayed %s, got %+v", context, result)
+		}
+		if _, err := manager.Activate(unused); !errors.Is(err, ErrReplayed) {
+			t.Errorf("Expected activating the unused copy to fail %s, got %v", context, err)
+		}
+	}
+
+	expectReplayed("after use")
+
+	state, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	if err := os.Remove(stateFile); err != nil {
+		t.Fatalf("Failed to delete state file: %v", err)
+	}
+	if err := os.WriteFile(licenseFile, unused, 0644); err != nil {
+		t.Fatalf("Failed to restore license file: %v", err)
+	}
+	if result, _ := manager.Validate(TestProductName); !errors.Is(result.Err, ErrReplayed) {
+		t.Errorf("Expected the unused copy without a usage record to be replayed, got %+v", result)
+	}
+	if err := os.WriteFile(stateFile, state, 0600); err != nil {
+		t.Fatalf("Failed to restore state file: %v", err)
+	}
+
+	if err := manager.Revoke(TestProductName); err != nil {
+		t.Fatalf("Failed to revoke license: %v", err)
+	}
+	expectReplayed("after revocation")
+
+	// A renewal is a new license and installs over the revoked one
+	renewal, _, err := manager.Issue(CreateLicenseRequest{ProductName: TestProductName, MaxDays: 30, TargetPCID: manager.PCID})
+	if err != nil {
+		t.Fatalf("Failed to issue renewal: %v", err)
+	}
+	if _, err := manager.Activate(renewal); err != nil {
+		t.Fatalf("Failed to activate renewal: %v", err)
+	}
+	if result, _ := manager.Validate(TestProductName); !result.IsValid {
+		t.Errorf("Expected the renewal to be valid, got %s", result.ErrorMessage)
+	}
+	if _, err := manager.Activate(renewal); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected activating the renewal twice to fail, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package license
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AmrEsam0/license-manager/pkg/crypto"
+)
+
+// List summarizes every license in the store without updating usage tracking.
+// Licenses that cannot be read, decrypted or verified are included with StatusInvalid
+// and the reason in Error.
+func (m *Manager) List() ([]LicenseSummary, error) {
+	products, err := m.listProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	summaries := make([]LicenseSummary, 0, len(products))
+	for _, product := range products {
+		summaries = append(summaries, m.summarize(product, now))
+	}
+	return summaries, nil
+}
+
+// listProducts returns the products in the store. A FileStore names licenses whose header does
+// not record the product after their file, so for those the product is read from the payload.
+func (m *Manager) listProducts() ([]string, error) {
+	names, err := m.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list license files: %v", err)
+	}
+	products := make([]string, 0, len(names))
+	for _, name := range names {
+		products = append(products, m.payloadProduct(name))
+	}
+	slices.Sort(products)
+	return slices.Compact(products), nil
+}
+
+// payloadProduct returns the product recorded in the payload of a license or revocation whose
+// header does not record it, or name when it cannot be read. The payload product is only used
+// when the store finds the same file under it, so a renamed file keeps its own name.
+func (m *Manager) payloadProduct(name string) string {
+	data, err := m.store.Get(name)
+	if err != nil {
+		return name
+	}
+	if header, _, err := crypto.ParseContainer(data); err != nil || header.Product != "" {
+		return name
+	}
+	plaintext, _, err := m.crypto.Open(data, "")
+	if err != nil {
+		return name
+	}
+	var payload struct {
+		ProductName string `json:"product_name"`
+	}
+	if err := json.Unmarshal(plaintext, &payload); err != nil || payload.ProductName == "" || payload.ProductName == name {
+		return name
+	}
+	if stored, err := m.store.Get(payload.ProductName); err != nil || !bytes.Equal(stored, data) {
+		return name
+	}
+	return payload.ProductName
+}
+
+// summarize describes a product's license as far as it can be decoded. It runs the checks of a
+// read-only validation, so the status is the one Validate would report.
+func (m *Manager) summarize(productName string, now time.Time) LicenseSummary {
+	summary := LicenseSummary{ProductName: productName, Status: StatusInvalid}
+
+	license, updated, err := m.readAndVerifyLicense(productName, m.PCID, true, now)
+	if license != nil {
+		summary.IsLifetime = license.IsLifetime
+		summary.ExpiryMode = license.Mode()
+		summary.MaxDays = license.MaxDays
+		summary.RemainingDays = license.RemainingDays(now)
+		summary.IsActivated = license.IsActivated
+		summary.RunCount = license.RunCount
+		summary.ValidForThisPC = m.checkMachine(license, m.PCID) == nil
+	}
+	if err != nil {
+		summary.Status = statusOf(err)
+		summary.Reason = ReasonOf(err)
+		summary.Error = err.Error()
+		return summary
+	}
+
+	summary.RemainingDays = updated.RemainingDays(now)
+	summary.Status, _ = updated.Status(now, m.config.WarnDays)
+	return summary
+}
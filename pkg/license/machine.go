@@ -0,0 +1,62 @@
+package license
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/AmrEsam0/license-manager/pkg/hardware"
+)
+
+// ExplainPCID lists the hardware probes behind this machine's PC ID, for diagnosing
+// licenses that are no longer valid for this PC
+func (m *Manager) ExplainPCID() ([]hardware.Probe, error) {
+	return hardware.Explain(m.fingerprinter)
+}
+
+// checkMachine accepts a license issued for this PC. When the PC ID differs and hardware
+// matching is enabled, enough of the recorded component fingerprints must still agree.
+func (m *Manager) checkMachine(license *License, currentPcId string) error {
+	if m.sameMachine(license, currentPcId) {
+		return nil
+	}
+
+	threshold := m.config.HardwareMatchThreshold
+	if threshold == 0 || len(license.Fingerprints) == 0 {
+		return ErrWrongMachine
+	}
+
+	drifted := m.hardwareDrift(license, currentPcId)
+	matched := len(license.Fingerprints) - len(drifted)
+	if matched < threshold {
+		return fmt.Errorf("%w - %d of %d hardware components match, %d required (changed: %s)", ErrWrongMachine,
+			matched, len(license.Fingerprints), threshold, strings.Join(drifted, ", "))
+	}
+	return nil
+}
+
+// hardwareDrift returns the sorted names of the license's hardware components that no
+// longer match this machine, or nil when the license was issued for this exact PC ID
+func (m *Manager) hardwareDrift(license *License, currentPcId string) []string {
+	if m.sameMachine(license, currentPcId) {
+		return nil
+	}
+
+	var drifted []string
+	for name, fingerprint := range license.Fingerprints {
+		if m.fingerprints[name] != fingerprint {
+			drifted = append(drifted, name)
+		}
+	}
+	slices.Sort(drifted)
+	return drifted
+}
+
+// sameMachine reports whether the license was issued for this exact PC ID,
+// or for the ID this machine had under an earlier PC ID scheme
+func (m *Manager) sameMachine(license *License, currentPcId string) bool {
+	if license.PCId == currentPcId {
+		return true
+	}
+	return currentPcId == m.PCID && slices.Contains(m.legacyPCIDs, license.PCId)
+}
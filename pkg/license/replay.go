@@ -0,0 +1,285 @@
+package license
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AmrEsam0/license-manager/pkg/config"
+)
+
+// Replay detection.
+// Usage state lives inside the license file, so restoring a backup of the file would reset it.
+// Every recorded run therefore increments the license's Generation and links it to the
+// previous state through PrevStateHash, and every save mirrors the generation and the hash of
+// the new state in a separate state store, starting with generation 0 when a license is
+// installed. A license file older than the mirrored generation, one whose history does not
+// match it, or one without a record was restored from a copy.
+
+// usageState is the sealed record the state store keeps for each product
+type usageState struct {
+	ProductName string `json:"product_name"`
+	// Serial of the installed license, empty after a revocation
+	Serial     string `json:"serial,omitempty"`
+	Generation uint64 `json:"generation"`
+	StateHash  string `json:"state_hash"`
+	// Retired lists the serials of licenses installed before, which cannot be activated again
+	Retired []string `json:"retired,omitempty"`
+	// HighWater is the latest use of any license of the product, a time anchor for checkClock
+	HighWater time.Time `json:"high_water"`
+}
+
+// stateHash returns the hex SHA-256 of the license's JSON encoding, usage state included.
+// The serial is left out because it is re-issued in place when keys or serial formats migrate.
+func (l *License) stateHash() (string, error) {
+	c := *l
+	c.Serial = ""
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal license: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// advanceGeneration links the license to its current state before a run is recorded
+func (l *License) advanceGeneration() error {
+	hash, err := l.stateHash()
+	if err != nil {
+		return err
+	}
+	l.PrevStateHash = hash
+	l.Generation++
+	return nil
+}
+
+// recordState mirrors the generation and state hash of a saved license in the state store
+func (m *Manager) recordState(license *License) error {
+	if m.stateStore == nil {
+		return nil
+	}
+	hash, err := license.stateHash()
+	if err != nil {
+		return err
+	}
+	previous, err := m.loadState(license.ProductName)
+	if err != nil {
+		return err
+	}
+	return m.putState(usageState{
+		ProductName: license.ProductName,
+		Serial:      license.Serial,
+		Generation:  license.Generation,
+		StateHash:   hash,
+		Retired:     previous.retire(license.Serial),
+		HighWater:   previous.raise(license),
+	})
+}
+
+// recordRevocation moves the usage record of a product past its license, so restoring a copy
+// or activating it again does not undo the revocation
+func (m *Manager) recordRevocation(productName string, generation uint64) error {
+	if m.stateStore == nil {
+		return nil
+	}
+	previous, err := m.loadState(productName)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.Generation > generation {
+		generation = previous.Generation
+	}
+	return m.putState(usageState{
+		ProductName: productName,
+		Generation:  generation + 1,
+		StateHash:   "revoked",
+		Retired:     previous.retire(""),
+		HighWater:   previous.highWater(),
+	})
+}
+
+// retire returns the retired serials once the recorded license is replaced by serial
+func (s *usageState) retire(serial string) []string {
+	if s == nil {
+		return nil
+	}
+	retired := slices.Clone(s.Retired)
+	if s.Serial != "" && s.Serial != serial && !slices.Contains(retired, s.Serial) {
+		retired = append(retired, s.Serial)
+	}
+	return retired
+}
+
+// highWater returns the recorded high-water mark, the zero time without a record
+func (s *usageState) highWater() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return s.HighWater
+}
+
+// raise returns the high-water mark once the license's last use is recorded
+func (s *usageState) raise(license *License) time.Time {
+	mark := s.highWater()
+	if lastUsed, ok := license.lastUsed(); ok && lastUsed.After(mark) {
+		mark = lastUsed.UTC()
+	}
+	return mark
+}
+
+// checkInstall refuses to install a license that was installed on this machine before,
+// since its usage would start over
+func (m *Manager) checkInstall(license *License) error {
+	if m.stateStore == nil {
+		return nil
+	}
+	state, err := m.loadState(license.ProductName)
+	if err != nil || state == nil {
+		return err
+	}
+	if state.Serial == license.Serial || slices.Contains(state.Retired, license.Serial) {
+		return fmt.Errorf("%w - the license has already been installed", ErrReplayed)
+	}
+	return nil
+}
+
+// putState seals a usage record and writes it to the state store
+func (m *Manager) putState(state usageState) error {
+	if m.stateStore == nil {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage state: %v", err)
+	}
+	sealed, err := m.crypto.Seal(data, state.ProductName)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt usage state: %v", err)
+	}
+	return m.stateStore.Put(state.ProductName, sealed)
+}
+
+// loadState returns the usage record of a product, or nil when there is none. A record that
+// cannot be decrypted or belongs to another product counts as missing.
+func (m *Manager) loadState(productName string) (*usageState, error) {
+	sealed, err := m.stateStore.Get(productName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage state: %v", err)
+	}
+	data, _, err := m.crypto.Open(sealed, productName)
+	if err != nil {
+		return nil, nil
+	}
+	var state usageState
+	if err := json.Unmarshal(data, &state); err != nil || state.ProductName != productName {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// checkState reports ErrReplayed when the license is not the copy the state store last recorded
+func (m *Manager) checkState(license *License) error {
+	if m.stateStore == nil {
+		return nil
+	}
+	state, err := m.loadState(license.ProductName)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		// Licenses issued before replay detection existed, used or not, have no record until
+		// their next save creates it. Installing any later license records generation 0.
+		if !license.ReplayProtected && license.Generation == 0 && license.PrevStateHash == "" {
+			return nil
+		}
+		return fmt.Errorf("%w - its usage record is missing", ErrReplayed)
+	}
+
+	hash, err := license.stateHash()
+	if err != nil {
+		return err
+	}
+	switch {
+	case state.Generation == license.Generation && state.StateHash == hash:
+		return nil
+	case state.Generation+1 == license.Generation && state.StateHash == license.PrevStateHash:
+		// The last save stopped after writing the license; the next save updates the record
+		return nil
+	case state.Generation > license.Generation:
+		return fmt.Errorf("%w - it is generation %d, but generation %d was already recorded", ErrReplayed, license.Generation, state.Generation)
+	default:
+		return fmt.Errorf("%w - its usage history does not match recorded generation %d", ErrReplayed, state.Generation)
+	}
+}
+
+// stateFileStore keeps usage records in <product>.state files in the state directory, away from
+// the license files so that wiping or restoring the license directory leaves them in place.
+// It is the default state store.
+type stateFileStore struct {
+	config *config.Config
+}
+
+// Get reads the usage record of a product
+func (s *stateFileStore) Get(productName string) ([]byte, error) {
+	path, err := s.config.GetStateFilePathForProduct(productName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state file path: %v", err)
+	}
+	return os.ReadFile(path)
+}
+
+// Put atomically replaces the usage record of a product
+func (s *stateFileStore) Put(productName string, data []byte) error {
+	path, err := s.config.GetStateFilePathForProduct(productName)
+	if err != nil {
+		return fmt.Errorf("failed to get state file path: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// Delete removes the usage record of a product
+func (s *stateFileStore) Delete(productName string) error {
+	path, err := s.config.GetStateFilePathForProduct(productName)
+	if err != nil {
+		return fmt.Errorf("failed to get state file path: %v", err)
+	}
+	return os.Remove(path)
+}
+
+// List returns the sanitized product names of all usage records
+func (s *stateFileStore) List() ([]string, error) {
+	dir, err := s.config.GetStateDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var products []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasSuffix(name, ".state") {
+			products = append(products, strings.TrimSuffix(name, ".state"))
+		}
+	}
+	slices.Sort(products)
+	return products, nil
+}
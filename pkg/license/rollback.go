@@ -0,0 +1,178 @@
+package license
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Clock rollback detection.
+// The license file records when it was last used, but a user can set the clock back and
+// restore an older copy of the file, or delete it and start over. Every validation therefore
+// also compares the clock against time anchors kept elsewhere: the latest use mirrored in the
+// license's usage record, which outlives it, and the modification times of system files that
+// the OS updates as it runs. The clock may not be behind any of them by more than the configured
+// skew. All times are compared as instants, so timezone offsets do not matter.
+
+// TimeAnchor records a time the clock has been seen to reach, independently of the license file
+type TimeAnchor interface {
+	// Name identifies the anchor in rollback errors
+	Name() string
+	// HighWater returns the latest time recorded for a product, the zero time when none is
+	HighWater(productName string) (time.Time, error)
+	// Record raises the product's high-water mark to t. Anchors the manager cannot write,
+	// such as system files, ignore it.
+	Record(productName string, t time.Time) error
+}
+
+// stateAnchor is the high-water mark kept in the product's usage record. The record outlives
+// the license file and lives outside the license directory, so restoring an older license
+// file or deleting it and installing a new one does not reset it.
+type stateAnchor struct {
+	manager *Manager
+}
+
+// Name identifies the usage record in rollback errors
+func (a *stateAnchor) Name() string {
+	return "usage record"
+}
+
+// HighWater returns the mark in the usage record, the zero time without a record or state store
+func (a *stateAnchor) HighWater(productName string) (time.Time, error) {
+	if a.manager.stateStore == nil {
+		return time.Time{}, nil
+	}
+	state, err := a.manager.loadState(productName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return state.highWater(), nil
+}
+
+// Record does nothing; every save of the license raises the mark in its usage record
+func (a *stateAnchor) Record(string, time.Time) error {
+	return nil
+}
+
+// SystemFileAnchor uses the latest modification time of system files the OS keeps updating,
+// such as login records and package databases. Files that do not exist are skipped.
+type SystemFileAnchor struct {
+	Paths []string
+}
+
+// DefaultSystemFileAnchor returns an anchor over well-known files of the running platform
+func DefaultSystemFileAnchor() *SystemFileAnchor {
+	switch runtime.GOOS {
+	case "windows":
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return &SystemFileAnchor{Paths: []string{
+			filepath.Join(root, `System32\config\SYSTEM`),
+			filepath.Join(root, `System32\config\SOFTWARE`),
+			filepath.Join(root, `System32\winevt\Logs\System.evtx`),
+		}}
+	case "darwin":
+		return &SystemFileAnchor{Paths: []string{
+			"/private/var/log/system.log",
+			"/private/var/log/wtmp",
+			"/Library/Preferences/SystemConfiguration/preferences.plist",
+		}}
+	default:
+		return &SystemFileAnchor{Paths: []string{
+			"/var/lib/systemd/timesync/clock",
+			"/var/log/wtmp",
+			"/var/log/lastlog",
+			"/var/lib/dpkg/status",
+			"/var/lib/rpm/rpmdb.sqlite",
+			"/var/lib/pacman/local",
+			"/etc/ld.so.cache",
+		}}
+	}
+}
+
+// Name identifies the system files in rollback errors
+func (a *SystemFileAnchor) Name() string {
+	return "system file times"
+}
+
+// HighWater returns the latest modification time among the files; it is the same for every product
+func (a *SystemFileAnchor) HighWater(string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range a.Paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Record does nothing; the OS updates the files
+func (a *SystemFileAnchor) Record(string, time.Time) error {
+	return nil
+}
+
+// defaultAnchors returns the usage record and, when enabled, the system file anchor.
+// The usage record always comes first.
+func (m *Manager) defaultAnchors() []TimeAnchor {
+	anchors := []TimeAnchor{&stateAnchor{manager: m}}
+	if m.config.SystemTimeAnchors {
+		anchors = append(anchors, DefaultSystemFileAnchor())
+	}
+	return anchors
+}
+
+// checkClock reports ErrClockRollback when now is behind the license's last use or any time
+// anchor by more than the configured skew
+func (m *Manager) checkClock(license *License, now time.Time) error {
+	check := func(source string, mark time.Time) error {
+		if mark.IsZero() || !now.Add(m.config.ClockSkew).Before(mark) {
+			return nil
+		}
+		behind := mark.Sub(now).Round(time.Second)
+		return fmt.Errorf("%w - the clock is %s behind the %s (%s)", ErrClockRollback, behind, source, mark.UTC().Format(time.RFC3339))
+	}
+
+	if lastUsed, ok := license.lastUsed(); ok {
+		if err := check("last recorded use", lastUsed); err != nil {
+			return err
+		}
+	}
+	for _, anchor := range m.anchors {
+		mark, err := anchor.HighWater(license.ProductName)
+		if err != nil {
+			return err
+		}
+		if err := check(anchor.Name(), mark); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordHighWater raises every anchor to now after a recorded run. It is best effort: an
+// anchor that cannot be written leaves the others and the license file to detect rollbacks.
+func (m *Manager) recordHighWater(productName string, now time.Time) {
+	for _, anchor := range m.anchors {
+		anchor.Record(productName, now)
+	}
+}
+
+// lastUsed returns the time of the license's last recorded run
+func (l *License) lastUsed() (time.Time, bool) {
+	if l.LastUsedDate == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, l.LastUsedDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,227 @@
+package license
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/AmrEsam0/license-manager/pkg/config"
+	"github.com/AmrEsam0/license-manager/pkg/crypto"
+)
+
+// Store persists encrypted license files by product name. The contents are opaque to the
+// store; they are already encrypted and authenticated by the manager.
+type Store interface {
+	// Get returns the license file contents of a product. The error satisfies
+	// errors.Is(err, fs.ErrNotExist) when the product has no license.
+	Get(productName string) ([]byte, error)
+	// Put creates or replaces the license file contents of a product
+	Put(productName string, data []byte) error
+	// Delete removes the license of a product
+	Delete(productName string) error
+	// List returns the names of all products with a license, sorted
+	List() ([]string, error)
+}
+
+// Locker is implemented by stores that can serialize the read-modify-write cycle of usage
+// tracking, across processes where the storage is shared. The manager holds the lock of a
+// product from reading its license until the updated license is written back.
+type Locker interface {
+	// Lock blocks until the product's lock is held and returns the function releasing it
+	Lock(productName string) (unlock func(), err error)
+}
+
+// FileStore keeps each product's license in <product>.license in the license directory,
+// LICENSE_DIR or the current directory. It is the default store.
+type FileStore struct {
+	config *config.Config
+}
+
+// NewFileStore creates a store in the license directory of cfg
+func NewFileStore(cfg *config.Config) *FileStore {
+	return &FileStore{config: cfg}
+}
+
+// Path returns the license file path of a product
+func (s *FileStore) Path(productName string) (string, error) {
+	return s.config.GetLicenseFilePathForProduct(productName)
+}
+
+// Get reads the license file of a product
+func (s *FileStore) Get(productName string) ([]byte, error) {
+	path, err := s.Path(productName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get license file path: %v", err)
+	}
+	return os.ReadFile(path)
+}
+
+// Put atomically replaces the license file of a product, creating the license directory
+// if needed. A crash leaves either the old or the new file, never a partial one.
+func (s *FileStore) Put(productName string, data []byte) error {
+	path, err := s.Path(productName)
+	if err != nil {
+		return fmt.Errorf("failed to get license file path: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// Delete removes the license file of a product
+func (s *FileStore) Delete(productName string) error {
+	path, err := s.Path(productName)
+	if err != nil {
+		return fmt.Errorf("failed to get license file path: %v", err)
+	}
+	return os.Remove(path)
+}
+
+// Lock takes an advisory lock on <product>.license.lock next to the license file.
+// It excludes other goroutines and other processes sharing the license directory.
+func (s *FileStore) Lock(productName string) (func(), error) {
+	path, err := s.Path(productName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get license file path: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	return lockFile(path + ".lock")
+}
+
+// List returns the products of all .license files in the license directory. The product is
+// taken from the file header, or from the file name for files that do not record it; the
+// manager reads the product of those from the encrypted payload.
+func (s *FileStore) List() ([]string, error) {
+	files, err := s.config.ListLicenseFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	products := make([]string, 0, len(files))
+	for _, file := range files {
+		product := strings.TrimSuffix(filepath.Base(file), ".license")
+		if data, err := os.ReadFile(file); err == nil {
+			if header, _, err := crypto.ParseContainer(data); err == nil && header.Product != "" {
+				product = header.Product
+			}
+		}
+		products = append(products, product)
+	}
+	slices.Sort(products)
+	return slices.Compact(products), nil
+}
+
+// MemoryStore keeps licenses in memory, for tests and applications that persist
+// licenses themselves. It is safe for concurrent use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	licenses map[string][]byte
+	locks    map[string]*sync.Mutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{licenses: make(map[string][]byte), locks: make(map[string]*sync.Mutex)}
+}
+
+// Lock takes the in-process lock of a product
+func (s *MemoryStore) Lock(productName string) (func(), error) {
+	s.mu.Lock()
+	lock, ok := s.locks[productName]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[productName] = lock
+	}
+	s.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock, nil
+}
+
+// Get returns a copy of the stored license of a product
+func (s *MemoryStore) Get(productName string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.licenses[productName]
+	if !ok {
+		return nil, fmt.Errorf("no license for product %s: %w", productName, fs.ErrNotExist)
+	}
+	return slices.Clone(data), nil
+}
+
+// Put stores a copy of the license of a product
+func (s *MemoryStore) Put(productName string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.licenses[productName] = slices.Clone(data)
+	return nil
+}
+
+// Delete removes the license of a product
+func (s *MemoryStore) Delete(productName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.licenses[productName]; !ok {
+		return fmt.Errorf("no license for product %s: %w", productName, fs.ErrNotExist)
+	}
+	delete(s.licenses, productName)
+	return nil
+}
+
+// List returns the products with a stored license, sorted
+func (s *MemoryStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	products := make([]string, 0, len(s.licenses))
+	for product := range s.licenses {
+		products = append(products, product)
+	}
+	slices.Sort(products)
+	return products, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the target directory, syncs it and
+// renames it over path, so readers see either the old or the new contents
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %v", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file mode: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %v", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", filepath.Base(path), err)
+	}
+
+	// Persist the rename itself. Directories cannot be synced on every platform (Windows),
+	// where the rename is still atomic but may not survive a power loss.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -2,6 +2,18 @@ package license
 
 import "time"
 
+// ExpiryMode selects how a license runs out
+type ExpiryMode string
+
+const (
+	// ExpiryUsageDays counts distinct days on which the product was validated (default)
+	ExpiryUsageDays ExpiryMode = "usage"
+	// ExpiryCalendar uses an absolute NotBefore/NotAfter wall clock window
+	ExpiryCalendar ExpiryMode = "calendar"
+	// ExpiryHybrid expires on usage days or the calendar window, whichever comes first
+	ExpiryHybrid ExpiryMode = "hybrid"
+)
+
 // License represents the license structure
 type License struct {
 	Serial       string          `json:"serial"`
@@ -16,6 +28,62 @@ type License struct {
 	IsActivated  bool            `json:"is_activated"`
 	UsageHistory []string        `json:"usage_history"`
 	UsageMap     map[string]bool `json:"usage_map,omitempty"`
+	Signature    string          `json:"signature,omitempty"`
+
+	// Generation counts recorded runs and PrevStateHash is the hash of the license before the
+	// last one. Both are mirrored in the state store so restored copies are detected.
+	// ReplayProtected marks licenses issued since then, which must always have a usage record.
+	Generation      uint64 `json:"generation,omitempty"`
+	PrevStateHash   string `json:"prev_state_hash,omitempty"`
+	ReplayProtected bool   `json:"replay_protected,omitempty"`
+
+	// Entitlements granted by the issuer, covered by the serial and signature
+	Features []string          `json:"features,omitempty"`
+	Limits   map[string]int    `json:"limits,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Calendar window, used by ExpiryCalendar and ExpiryHybrid licenses.
+	// An empty ExpiryMode means ExpiryUsageDays.
+	ExpiryMode ExpiryMode `json:"expiry_mode,omitempty"`
+	NotBefore  *time.Time `json:"not_before,omitempty"`
+	NotAfter   *time.Time `json:"not_after,omitempty"`
+
+	// GraceDays the license keeps working after it expires
+	GraceDays int `json:"grace_days,omitempty"`
+
+	// ActivationNonce echoes the activation request this license answers, empty for local licenses
+	ActivationNonce string `json:"activation_nonce,omitempty"`
+
+	// Fingerprints of the hardware components the license was issued for, keyed by
+	// component name. They allow fuzzy matching when some hardware changes.
+	Fingerprints map[string]string `json:"fingerprints,omitempty"`
+}
+
+// licenseClaims is the issuer-controlled part of a license, covered by the serial and signature.
+// Usage tracking fields are excluded because clients rewrite them on every validation;
+// they are protected by the file encryption instead.
+// Fields added later must use omitempty so older serials keep verifying.
+type licenseClaims struct {
+	PCId        string `json:"pc_id"`
+	ProductName string `json:"product_name"`
+	CreatedAt   string `json:"created_at"`
+	MaxDays     int    `json:"max_days"`
+	IsLifetime  bool   `json:"is_lifetime"`
+
+	Features []string          `json:"features,omitempty"`
+	Limits   map[string]int    `json:"limits,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	ExpiryMode ExpiryMode `json:"expiry_mode,omitempty"`
+	NotBefore  string     `json:"not_before,omitempty"`
+	NotAfter   string     `json:"not_after,omitempty"`
+
+	GraceDays int `json:"grace_days,omitempty"`
+
+	ActivationNonce string            `json:"activation_nonce,omitempty"`
+	Fingerprints    map[string]string `json:"fingerprints,omitempty"`
+
+	ReplayProtected bool `json:"replay_protected,omitempty"`
 }
 
 // LicenseInfo provides read-only license information
@@ -29,7 +97,35 @@ type LicenseInfo struct {
 	FirstRunDate  string // Changed from FirstActivated to match the License struct
 	LastUsedDate  string // Changed from LastUsed to match the License struct
 	UsageHistory  []string
+	Features      []string
+	Limits        map[string]int
+	Metadata      map[string]string
+	ExpiryMode    ExpiryMode
+	NotBefore     *time.Time
+	NotAfter      *time.Time
+	GraceDays     int
 	IsValid       bool
+
+	Status             LicenseStatus
+	GraceDaysRemaining int
+}
+
+// LicenseSummary describes one installed license, see Manager.List
+type LicenseSummary struct {
+	ProductName string     `json:"product_name"`
+	IsLifetime  bool       `json:"is_lifetime"`
+	ExpiryMode  ExpiryMode `json:"expiry_mode,omitempty"`
+	MaxDays     int        `json:"max_days"`
+	// RemainingDays is zero for lifetime licenses
+	RemainingDays int  `json:"remaining_days"`
+	IsActivated   bool `json:"is_activated"`
+	RunCount      int  `json:"run_count"`
+	// ValidForThisPC reports whether the license is bound to this machine
+	ValidForThisPC bool          `json:"valid_for_this_pc"`
+	Status         LicenseStatus `json:"status"`
+	// Reason and Error explain an invalid status, such as a license that cannot be decrypted
+	Reason Reason `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
 }
 
 // CreateLicenseRequest represents the parameters for creating a new license
@@ -37,6 +133,36 @@ type CreateLicenseRequest struct {
 	ProductName string
 	MaxDays     int
 	IsLifetime  bool
+
+	// TargetPCID binds the license to another machine, as printed by `license-manager pcid`.
+	// Empty binds it to this machine.
+	TargetPCID string
+
+	// Optional entitlements: feature flags, numeric limits and free-form metadata
+	Features []string
+	Limits   map[string]int
+	Metadata map[string]string
+
+	// Optional expiry mode; ExpiryCalendar and ExpiryHybrid require NotAfter.
+	// Zero times mean no bound.
+	ExpiryMode ExpiryMode
+	NotBefore  time.Time
+	NotAfter   time.Time
+
+	// GraceDays after expiry during which the license still validates.
+	// Zero uses the configured default; negative values are rejected.
+	GraceDays int
+	// NoGrace issues the license without a grace period, ignoring the configured default
+	NoGrace bool
+}
+
+// ValidateOptions controls ValidateWithOptions
+type ValidateOptions struct {
+	// ReadOnly performs every integrity, PC and expiry check, including whether this run
+	// would use up the last day, without recording the run. Nothing is written, so
+	// dashboards and health checks do not consume usage days. The result carries the
+	// license as stored, with the status and remaining days a real run would report.
+	ReadOnly bool
 }
 
 // ValidationResult contains the result of license validation
@@ -44,4 +170,40 @@ type ValidationResult struct {
 	IsValid      bool
 	License      *License
 	ErrorMessage string
+
+	// Reason is the machine-readable cause of a failed validation, and Err the error
+	// behind ErrorMessage, which wraps one of the sentinel errors such as ErrExpired
+	Reason Reason
+	Err    error
+
+	// Status refines IsValid so applications can warn before the license stops working
+	Status             LicenseStatus
+	RemainingDays      int
+	GraceDaysRemaining int
+
+	// DriftedComponents lists hardware components that changed since the license was issued
+	// but were tolerated by the hardware match threshold
+	DriftedComponents []string
+
+	// Warnings that do not invalidate the license, such as running in a container
+	// under the warn environment policy
+	Warnings []string
 }
+
+// LicenseStatus describes where a license is in its lifecycle
+type LicenseStatus string
+
+const (
+	// StatusValid means the license is valid with more than the warning threshold left
+	StatusValid LicenseStatus = "valid"
+	// StatusExpiringSoon means the license is valid but within the warning threshold
+	StatusExpiringSoon LicenseStatus = "expiring_soon"
+	// StatusGracePeriod means the license has expired but is still within its grace days
+	StatusGracePeriod LicenseStatus = "grace_period"
+	// StatusExpired means the license has expired and its grace period is over
+	StatusExpired LicenseStatus = "expired"
+	// StatusClockRollback means the clock is behind the license's last recorded use
+	StatusClockRollback LicenseStatus = "clock_rollback"
+	// StatusInvalid means the license is missing, corrupted or not valid for this machine or time
+	StatusInvalid LicenseStatus = "invalid"
+)